@@ -1,80 +1,307 @@
 package actors
 
 import (
+	"context"
 	"fmt"
-	"runtime/debug"
+	"log"
 	"sync"
+	"time"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/queue"
 )
 
-func NewActor(handler Handler) Actor {
-	actor := &actor{
-		handler:  handler,
-		Cond:     sync.NewCond(&sync.Mutex{}),
-		stopChan: make(chan bool, 1),
-	}
-	go run(actor)
-	return actor
+const (
+	ActorPanic   errors.ErrorCode = "actor_panic"
+	ActorStopped errors.ErrorCode = "actor_stopped"
+	AskTimeout   errors.ErrorCode = "ask_timeout"
+	MailboxFull  errors.ErrorCode = "mailbox_full"
+)
+
+func NewActor(handler Handler, options ...Option) Actor {
+	return start(nil, func() Handler { return handler }, options)
 }
 
 type Actor interface {
-	Send(message interface{})
+	Send(message interface{}) error
+	Ask(ctx context.Context, message interface{}) Future
+	Spawn(factory HandlerFactory, options ...Option) Actor
+	Len() int
+	Stats() queue.Stats
 	Shutdown()
 }
 
-var stopMsg = struct{ Stop struct{} }{}
-
 type Handler func(interface{})
+type HandlerFactory func() Handler
+
+// Directive tells a failed actor how to proceed.
+type Directive int
+
+const (
+	Resume   Directive = iota // keep the handler and continue with the next message
+	Restart                   // replace the handler with a fresh one from the factory, children are kept
+	Stop                      // stop the actor and its children
+	Escalate                  // stop the actor and fail its supervisor
+)
+
+// Failure is decided upon by the supervisor and then delivered to the supervisor's handler.
+type Failure struct {
+	Actor   Actor
+	Message interface{}
+	Err     errors.Error
+}
+
+type Decider func(failure Failure) Directive
+
+// Strategy is applied by an actor to failures of its children.
+// Children restarted more than MaxRestarts times within Within are stopped; zero MaxRestarts means no limit.
+type Strategy struct {
+	Decider     Decider
+	MaxRestarts int
+	Within      time.Duration
+}
+
+var DefaultStrategy = Strategy{Decider: func(Failure) Directive { return Restart }}
+
+type Sink func(err errors.Error)
+
+func logSink(err errors.Error) {
+	log.Println(err)
+}
+
+type Option func(a *actor)
+
+func WithStrategy(strategy Strategy) Option {
+	return func(a *actor) {
+		a.strategy = strategy
+	}
+}
+
+// WithMailbox bounds the number of pending messages; zero capacity means unbounded.
+// Supervision messages are not limited by the capacity.
+func WithMailbox(capacity int, policy queue.Policy) Option {
+	return func(a *actor) {
+		a.capacity = capacity
+		a.policy = policy
+	}
+}
+
+// WithSink sets where panics are reported; children inherit the sink of their parent.
+func WithSink(sink Sink) Option {
+	return func(a *actor) {
+		a.sink = sink
+	}
+}
 
 type actor struct {
 	handler  Handler
-	pending  []interface{}
-	stopChan chan bool
-	*sync.Cond
+	factory  HandlerFactory
+	parent   *actor
+	strategy Strategy
+	sink     Sink
+	restarts []time.Time
+	capacity int
+	policy   queue.Policy
+	mailbox  *mailbox
+	done     chan bool
+
+	childLock sync.Mutex
+	children  map[*actor]bool
+}
+
+type childFailure struct {
+	Failure
+	child     *actor
+	directive chan Directive
+}
+
+func start(parent *actor, factory HandlerFactory, options []Option) *actor {
+	a := &actor{
+		handler:  factory(),
+		factory:  factory,
+		parent:   parent,
+		strategy: DefaultStrategy,
+		sink:     logSink,
+		done:     make(chan bool),
+		children: map[*actor]bool{},
+	}
+	if parent != nil {
+		a.sink = parent.sink
+	}
+	for _, option := range options {
+		option(a)
+	}
+	a.mailbox = newMailbox(a.capacity, a.policy)
+	if parent != nil {
+		parent.childLock.Lock()
+		parent.children[a] = true
+		parent.childLock.Unlock()
+	}
+	go run(a)
+	return a
 }
 
 func run(a *actor) {
 	for {
-		a.Cond.L.Lock()
+		msg, ok := a.mailbox.next()
+		if !ok {
+			a.stop()
+			return
+		}
 
-		if len(a.pending) == 0 {
-			a.Cond.Wait()
-			a.Cond.L.Unlock()
+		if f, ok := msg.(childFailure); ok {
+			if !a.supervise(f) {
+				return
+			}
 			continue
 		}
 
-		msg := a.pending[0]
-		a.pending = a.pending[1:]
-
-		a.Cond.L.Unlock()
-		a.handleMessage(msg)
-
-		if msg == stopMsg {
-			a.stopChan <- true
-			return
+		if err, failed := a.handleMessage(msg); failed {
+			if request, ok := msg.(Request); ok {
+				request.future.resolve(nil, err)
+			}
+			a.sink(err)
+			if !a.fail(Failure{Actor: a, Message: msg, Err: err}) {
+				return
+			}
 		}
 	}
 }
 
-func (a *actor) handleMessage(msg interface{}) {
+func (a *actor) handleMessage(msg interface{}) (err errors.Error, failed bool) {
 	defer func() {
 		if r := recover(); r != nil {
-			a.Cond.L.Lock()
-			fmt.Printf("Actor %v panic: %v\n", a, r)
-			fmt.Println(string(debug.Stack()))
-			a.Cond.L.Unlock()
+			err = errors.NewError(errors.Alert, ActorPanic, "Actor panicked.", es.Info{"panic": fmt.Sprint(r)})
+			failed = true
 		}
 	}()
 	a.handler(msg)
+	return err, false
+}
+
+// fail asks the supervisor what to do about the failure and applies the directive.
+// Actors without supervisor resume. Returns false if the actor has stopped.
+func (a *actor) fail(failure Failure) bool {
+	directive := Resume
+	if a.parent != nil {
+		f := childFailure{Failure: failure, child: a, directive: make(chan Directive, 1)}
+		a.parent.mailbox.putSystem(f)
+		select {
+		case directive = <-f.directive:
+		case <-a.parent.done:
+			directive = Stop
+		}
+	}
+
+	switch directive {
+	case Restart:
+		a.handler = a.factory()
+	case Stop, Escalate:
+		a.stop()
+		return false
+	}
+	return true
+}
+
+func (a *actor) supervise(f childFailure) bool {
+	directive := Restart
+	if a.strategy.Decider != nil {
+		directive = a.strategy.Decider(f.Failure)
+	}
+	if directive == Restart && !f.child.allowRestart(a.strategy) {
+		a.sink(errors.NewError(errors.Alert, ActorPanic, "Actor restarted too many times and is stopped.", es.Info{
+			"max_restarts": a.strategy.MaxRestarts,
+			"within":       a.strategy.Within.String(),
+		}))
+		directive = Stop
+	}
+	f.directive <- directive
+
+	if directive == Escalate {
+		return a.fail(Failure{Actor: a, Message: f.Message, Err: f.Err})
+	}
+
+	if err, failed := a.handleMessage(f.Failure); failed {
+		a.sink(err)
+		return a.fail(Failure{Actor: a, Message: f.Failure, Err: err})
+	}
+	return true
+}
+
+func (a *actor) allowRestart(strategy Strategy) bool {
+	if strategy.MaxRestarts <= 0 {
+		return true
+	}
+	now := time.Now()
+	var recent []time.Time
+	for _, restart := range a.restarts {
+		if strategy.Within <= 0 || now.Sub(restart) < strategy.Within {
+			recent = append(recent, restart)
+		}
+	}
+	a.restarts = append(recent, now)
+	return len(a.restarts) <= strategy.MaxRestarts
+}
+
+func (a *actor) stop() {
+	for _, msg := range a.mailbox.close() {
+		rejectRequest(msg, errors.NewError(errors.Failure, ActorStopped, "Actor is stopped."))
+	}
+
+	close(a.done)
+	a.stopChildren()
+
+	if a.parent != nil {
+		a.parent.childLock.Lock()
+		delete(a.parent.children, a)
+		a.parent.childLock.Unlock()
+	}
+}
+
+func (a *actor) stopChildren() {
+	a.childLock.Lock()
+	children := make([]*actor, 0, len(a.children))
+	for child := range a.children {
+		children = append(children, child)
+	}
+	a.childLock.Unlock()
+
+	for _, child := range children {
+		child.Shutdown()
+	}
+}
+
+func (a *actor) Send(msg interface{}) error {
+	return a.mailbox.put(context.Background(), msg)
+}
+
+// Ask delivers the message to the handler wrapped into Request.
+// The returned future is resolved when the handler replies, fails or the actor stops;
+// with Block policy it fails if ctx is done while the mailbox is full.
+func (a *actor) Ask(ctx context.Context, msg interface{}) Future {
+	f := &future{ctx: ctx, done: make(chan bool)}
+	if err := a.mailbox.put(ctx, Request{Message: msg, future: f}); err != nil {
+		f.resolve(nil, err)
+	}
+	return f
+}
+
+func (a *actor) Len() int {
+	return a.mailbox.len()
+}
+
+func (a *actor) Stats() queue.Stats {
+	return a.mailbox.getStats()
 }
 
-func (a *actor) Send(msg interface{}) {
-	a.Cond.L.Lock()
-	a.pending = append(a.pending, msg)
-	a.Cond.Signal()
-	a.Cond.L.Unlock()
+// Spawn starts a child actor supervised by a.
+func (a *actor) Spawn(factory HandlerFactory, options ...Option) Actor {
+	return start(a, factory, options)
 }
 
+// Shutdown stops the actor after pending messages are handled.
 func (a *actor) Shutdown() {
-	a.Send(stopMsg)
-	<-a.stopChan
+	a.mailbox.requestStop()
+	<-a.done
 }
@@ -0,0 +1,174 @@
+package actors
+
+import (
+	"context"
+	stderrors "errors"
+	"testing"
+	"time"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/queue"
+)
+
+func counter(counts chan int) HandlerFactory {
+	return func() Handler {
+		count := 0
+		return func(msg interface{}) {
+			if msg == "fail" {
+				panic("FUBAR")
+			}
+			count++
+			counts <- count
+		}
+	}
+}
+
+func TestRestartWithFreshState(t *testing.T) {
+	failures := make(chan Failure, 1)
+	sinkErrors := make(chan errors.Error, 1)
+	supervisor := NewActor(func(msg interface{}) {
+		if failure, ok := msg.(Failure); ok {
+			failures <- failure
+		}
+	}, WithSink(func(err errors.Error) { sinkErrors <- err }))
+	defer supervisor.Shutdown()
+
+	counts := make(chan int, 3)
+	child := supervisor.Spawn(counter(counts))
+	child.Send("inc")
+	child.Send("fail")
+	child.Send("inc")
+
+	if count := <-counts; count != 1 {
+		t.Fatalf("Expected 1, got %d", count)
+	}
+	if err := <-sinkErrors; err.Severity != errors.Alert || err.Code != ActorPanic {
+		t.Fatalf("Unexpected error reported: %v", err)
+	}
+	if failure := <-failures; failure.Message != "fail" {
+		t.Fatalf("Unexpected failure: %v", failure)
+	}
+	if count := <-counts; count != 1 {
+		t.Fatalf("Expected state to be reset after restart, got %d", count)
+	}
+}
+
+func TestResume(t *testing.T) {
+	supervisor := NewActor(func(interface{}) {},
+		WithStrategy(Strategy{Decider: func(Failure) Directive { return Resume }}),
+		WithSink(func(errors.Error) {}))
+	defer supervisor.Shutdown()
+
+	counts := make(chan int, 3)
+	child := supervisor.Spawn(counter(counts))
+	child.Send("inc")
+	child.Send("fail")
+	child.Send("inc")
+
+	<-counts
+	if count := <-counts; count != 2 {
+		t.Fatalf("Expected state to be kept after resume, got %d", count)
+	}
+}
+
+func TestRestartLimit(t *testing.T) {
+	supervisor := NewActor(func(interface{}) {},
+		WithStrategy(Strategy{MaxRestarts: 1, Within: time.Minute}),
+		WithSink(func(errors.Error) {}))
+	defer supervisor.Shutdown()
+
+	counts := make(chan int, 3)
+	child := supervisor.Spawn(counter(counts)).(*actor)
+	child.Send("fail")
+	child.Send("fail")
+	child.Send("inc")
+
+	select {
+	case <-child.done:
+	case <-time.After(time.Second):
+		t.Fatalf("Expected child to be stopped.")
+	}
+	if len(counts) != 0 {
+		t.Fatalf("Stopped child handled a message.")
+	}
+}
+
+func TestEscalate(t *testing.T) {
+	root := NewActor(func(interface{}) {}, WithSink(func(errors.Error) {}))
+	defer root.Shutdown()
+
+	supervisor := root.Spawn(func() Handler { return func(interface{}) {} },
+		WithStrategy(Strategy{Decider: func(Failure) Directive { return Escalate }})).(*actor)
+	child := supervisor.Spawn(counter(make(chan int, 1))).(*actor)
+	child.Send("fail")
+
+	<-child.done
+	select {
+	case <-supervisor.done:
+		t.Fatalf("Expected supervisor to be restarted rather than stopped.")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestAsk(t *testing.T) {
+	a := NewActor(func(msg interface{}) {
+		request := msg.(Request)
+		if request.Message == "ping" {
+			request.Reply("pong")
+		}
+	})
+
+	reply, err := a.Ask(context.Background(), "ping").Result()
+	if err != nil || reply != "pong" {
+		t.Fatalf("Unexpected reply %v, %v", reply, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, err = a.Ask(ctx, "silence").Result()
+	if e, ok := err.(errors.Error); !ok || e.Code != AskTimeout {
+		t.Fatalf("Expected timeout, got %v", err)
+	}
+
+	a.Shutdown()
+	_, err = a.Ask(context.Background(), "ping").Result()
+	if e, ok := err.(errors.Error); !ok || e.Code != ActorStopped {
+		t.Fatalf("Expected stopped actor error, got %v", err)
+	}
+}
+
+func TestBoundedMailbox(t *testing.T) {
+	release := make(chan bool)
+	a := NewActor(func(interface{}) { <-release }, WithMailbox(1, queue.Reject))
+	defer a.Shutdown()
+
+	a.Send(1)
+	for a.Len() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	a.Send(2)
+	if err, ok := a.Send(3).(errors.Error); !ok || err.Code != MailboxFull {
+		t.Fatalf("Expected full mailbox, got %v", err)
+	}
+	close(release)
+}
+
+func TestAskOnBlockedMailbox(t *testing.T) {
+	release := make(chan bool)
+	a := NewActor(func(interface{}) { <-release }, WithMailbox(1, queue.Block))
+	defer a.Shutdown()
+
+	a.Send(1)
+	for a.Len() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	a.Send(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := a.Ask(ctx, 3).Result()
+	if e, ok := err.(errors.Error); !ok || e.Code != AskTimeout || !stderrors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected blocked ask to time out, got %v", err)
+	}
+	close(release)
+}
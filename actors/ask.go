@@ -0,0 +1,65 @@
+package actors
+
+import (
+	"context"
+	"sync"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+)
+
+// Request is what handler receives for messages sent with Ask.
+type Request struct {
+	Message interface{}
+	future  *future
+}
+
+func (r Request) Context() context.Context {
+	return r.future.ctx
+}
+
+// Reply resolves the future of the sender; only the first reply counts.
+func (r Request) Reply(value interface{}) {
+	r.future.resolve(value, nil)
+}
+
+func (r Request) Fail(err error) {
+	r.future.resolve(nil, err)
+}
+
+type Future interface {
+	Done() <-chan bool
+	Result() (interface{}, error)
+}
+
+type future struct {
+	once  sync.Once
+	ctx   context.Context
+	done  chan bool
+	value interface{}
+	err   error
+}
+
+func (f *future) resolve(value interface{}, err error) {
+	f.once.Do(func() {
+		f.value = value
+		f.err = err
+		close(f.done)
+	})
+}
+
+func (f *future) Done() <-chan bool {
+	return f.done
+}
+
+// Result waits for the reply or for the context of Ask to be done.
+func (f *future) Result() (interface{}, error) {
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-f.ctx.Done():
+		f.resolve(nil, errors.NewError(errors.Failure, AskTimeout, "Actor did not reply in time.", es.Info{"reason": f.ctx.Err().Error()}))
+		<-f.done
+		return f.value, f.err
+	}
+}
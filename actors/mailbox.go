@@ -0,0 +1,160 @@
+package actors
+
+import (
+	"context"
+	"sync"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/queue"
+)
+
+// mailbox keeps supervision messages apart from regular ones,
+// so that they are never dropped and are handled first.
+type mailbox struct {
+	system   *queue.Ring
+	pending  *queue.Ring
+	policy   queue.Policy
+	stats    queue.Stats
+	stopping bool
+	stopped  bool
+	notFull  *sync.Cond
+	*sync.Cond
+}
+
+func newMailbox(capacity int, policy queue.Policy) *mailbox {
+	mutex := &sync.Mutex{}
+	return &mailbox{
+		system:  queue.NewRing(0),
+		pending: queue.NewRing(capacity),
+		policy:  policy,
+		notFull: sync.NewCond(mutex),
+		Cond:    sync.NewCond(mutex),
+	}
+}
+
+// put blocks on the full mailbox with Block policy until there is room or ctx is done.
+func (m *mailbox) put(ctx context.Context, msg interface{}) error {
+	m.Cond.L.Lock()
+	defer m.Cond.L.Unlock()
+
+	var watching chan bool
+	for !m.stopping && m.pending.Full() && m.policy == queue.Block {
+		if err := ctx.Err(); err != nil {
+			m.stats.Rejected++
+			return errors.Wrap(err, errors.Failure, AskTimeout, "Actor mailbox stayed full.", es.Info{"capacity": m.pending.Cap(), "reason": err.Error()})
+		}
+		if watching == nil && ctx.Done() != nil {
+			watching = make(chan bool)
+			defer close(watching)
+			go m.wakeOnDone(ctx, watching)
+		}
+		m.notFull.Wait()
+	}
+
+	if m.stopping {
+		return errors.NewError(errors.Failure, ActorStopped, "Actor is stopped.")
+	}
+
+	if m.pending.Full() {
+		switch m.policy {
+		case queue.DropOldest:
+			dropped, _ := m.pending.Pop()
+			m.stats.Dropped++
+			rejectRequest(dropped, errors.NewError(errors.Failure, MailboxFull, "Message was dropped from full mailbox."))
+		case queue.DropNewest:
+			m.stats.Dropped++
+			rejectRequest(msg, errors.NewError(errors.Failure, MailboxFull, "Message was dropped from full mailbox."))
+			return nil
+		default:
+			m.stats.Rejected++
+			return errors.NewError(errors.Failure, MailboxFull, "Actor mailbox is full.", es.Info{"capacity": m.pending.Cap()})
+		}
+	}
+
+	m.pending.Push(msg)
+	m.stats.Put++
+	m.Cond.Signal()
+	return nil
+}
+
+// wakeOnDone wakes blocked put when ctx is done.
+func (m *mailbox) wakeOnDone(ctx context.Context, watching chan bool) {
+	select {
+	case <-ctx.Done():
+		m.Cond.L.Lock()
+		m.notFull.Broadcast()
+		m.Cond.L.Unlock()
+	case <-watching:
+	}
+}
+
+func (m *mailbox) putSystem(msg interface{}) bool {
+	m.Cond.L.Lock()
+	defer m.Cond.L.Unlock()
+	if m.stopped {
+		return false
+	}
+	m.system.Push(msg)
+	m.Cond.Signal()
+	return true
+}
+
+// next blocks until there is a message; returns false once stop is requested and regular messages are drained.
+func (m *mailbox) next() (interface{}, bool) {
+	m.Cond.L.Lock()
+	defer m.Cond.L.Unlock()
+
+	for m.system.Len() == 0 && m.pending.Len() == 0 && !m.stopping {
+		m.Cond.Wait()
+	}
+
+	if msg, ok := m.system.Pop(); ok {
+		return msg, true
+	}
+	if msg, ok := m.pending.Pop(); ok {
+		m.stats.Got++
+		m.notFull.Signal()
+		return msg, true
+	}
+	return nil, false
+}
+
+func (m *mailbox) requestStop() {
+	m.Cond.L.Lock()
+	m.stopping = true
+	m.Cond.Signal()
+	m.notFull.Broadcast()
+	m.Cond.L.Unlock()
+}
+
+// close returns messages that will never be handled.
+func (m *mailbox) close() []interface{} {
+	m.Cond.L.Lock()
+	defer m.Cond.L.Unlock()
+	m.stopping = true
+	m.stopped = true
+	m.notFull.Broadcast()
+	return append(m.system.Drain(), m.pending.Drain()...)
+}
+
+func (m *mailbox) len() int {
+	m.Cond.L.Lock()
+	defer m.Cond.L.Unlock()
+	return m.pending.Len()
+}
+
+func (m *mailbox) getStats() queue.Stats {
+	m.Cond.L.Lock()
+	defer m.Cond.L.Unlock()
+	stats := m.stats
+	stats.Len = m.pending.Len()
+	stats.Capacity = m.pending.Cap()
+	return stats
+}
+
+func rejectRequest(msg interface{}, err error) {
+	if request, ok := msg.(Request); ok {
+		request.future.resolve(nil, err)
+	}
+}
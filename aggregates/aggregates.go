@@ -75,11 +75,14 @@ func diffInfo(oldInfo, newInfo, diff es.Info) {
 
 func diffInfoRemoved(oldInfo, newInfo, diff es.Info) {
 	for k, oldValue := range oldInfo {
-		newValue, ok1 := newInfo[k]
-		newValueInfo, ok2 := newValue.(es.Info)
-		if !ok1 || !ok2 {
+		newValue, ok := newInfo[k]
+		if !ok || newValue == nil {
 			diff[k] = nil
-		} else if oldValueInfo, ok := oldValue.(es.Info); ok {
+			continue
+		}
+		newValueInfo, ok1 := newValue.(es.Info)
+		oldValueInfo, ok2 := oldValue.(es.Info)
+		if ok1 && ok2 {
 			elementDiff := es.Info{}
 			diffInfoRemoved(oldValueInfo, newValueInfo, elementDiff)
 			if len(elementDiff) > 0 {
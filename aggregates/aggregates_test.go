@@ -125,3 +125,25 @@ func TestDiff(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestDiffUnchanged(t *testing.T) {
+	oldInfo := es.Info{
+		"a": "aaa",
+		"b": 1.0,
+		"x": es.Info{"x1": "aaa"},
+	}
+	newInfo := es.Info{
+		"a": "aaa",
+		"b": 2.0,
+		"x": es.Info{"x1": "aaa"},
+	}
+	diff := Diff(oldInfo, newInfo)
+	expected := es.Info{
+		"b": 2.0,
+	}
+
+	if !reflect.DeepEqual(expected, diff) {
+		log.Printf("Expected %s\n Got %s\n", json.Encode(expected), json.Encode(diff))
+		t.Fail()
+	}
+}
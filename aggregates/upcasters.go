@@ -0,0 +1,68 @@
+package aggregates
+
+import (
+	"github.com/andrew-suprun/legion/es"
+)
+
+// Upcaster transforms Info of an event of version N into the shape of version N+1.
+type Upcaster func(info es.Info) es.Info
+
+// Upcasters keeps upcasters by entity type and schema version they upcast from.
+// Events without schema version are considered to be of version 1.
+type Upcasters struct {
+	upcasters map[es.EntityType]map[int]Upcaster
+}
+
+func NewUpcasters() *Upcasters {
+	return &Upcasters{upcasters: map[es.EntityType]map[int]Upcaster{}}
+}
+
+func (u *Upcasters) Register(et es.EntityType, fromVersion int, upcaster Upcaster) *Upcasters {
+	typeUpcasters, ok := u.upcasters[et]
+	if !ok {
+		typeUpcasters = map[int]Upcaster{}
+		u.upcasters[et] = typeUpcasters
+	}
+	typeUpcasters[fromVersion] = upcaster
+	return u
+}
+
+// Upcast applies upcasters in a chain until the event reaches the latest registered version.
+// Upcasters work on a copy of Info, so they are free to modify it.
+func (u *Upcasters) Upcast(event es.Event) es.Event {
+	if u == nil {
+		return event
+	}
+	version := event.Metadata.SchemaVersion
+	if version == 0 {
+		version = 1
+	}
+	typeUpcasters := u.upcasters[event.EntityType]
+	if _, ok := typeUpcasters[version]; ok {
+		event.Info = copyInfo(event.Info)
+	}
+	for {
+		upcaster, ok := typeUpcasters[version]
+		if !ok {
+			break
+		}
+		event.Info = upcaster(event.Info)
+		version++
+	}
+	event.Metadata.SchemaVersion = version
+	return event
+}
+
+func copyInfo(info es.Info) es.Info {
+	if info == nil {
+		return nil
+	}
+	result := es.Info{}
+	for k, v := range info {
+		if vInfo, ok := v.(es.Info); ok {
+			v = copyInfo(vInfo)
+		}
+		result[k] = v
+	}
+	return result
+}
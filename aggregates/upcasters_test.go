@@ -0,0 +1,56 @@
+package aggregates
+
+import (
+	"log"
+	"reflect"
+	"testing"
+
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/json"
+)
+
+func TestChainedUpcast(t *testing.T) {
+	upcasters := NewUpcasters().
+		Register("user", 1, func(info es.Info) es.Info {
+			if name, ok := info["name"]; ok {
+				info["full_name"] = name
+				delete(info, "name")
+			}
+			return info
+		}).
+		Register("user", 2, func(info es.Info) es.Info {
+			if fullName, ok := info["full_name"]; ok {
+				info["profile"] = es.Info{"full_name": fullName}
+				delete(info, "full_name")
+			}
+			return info
+		})
+
+	v1 := es.Event{EntityType: "user", Info: es.Info{"name": "John"}}
+	v2 := es.Event{EntityType: "user", Metadata: es.Metadata{SchemaVersion: 2}, Info: es.Info{"full_name": "Jane"}}
+	v3 := es.Event{EntityType: "user", Metadata: es.Metadata{SchemaVersion: 3}, Info: es.Info{"profile": es.Info{"full_name": "Jim"}}}
+
+	entity := es.Info{}
+	for _, event := range []es.Event{v1, v2, v3} {
+		upcasted := upcasters.Upcast(event)
+		if upcasted.Metadata.SchemaVersion != 3 {
+			t.Fatalf("Expected version 3, got %d", upcasted.Metadata.SchemaVersion)
+		}
+		Aggregate(entity, upcasted.Info)
+	}
+
+	expected := es.Info{"profile": es.Info{"full_name": "Jim"}}
+	if !reflect.DeepEqual(expected, entity) {
+		log.Printf("Expected %s\n Got %s\n", json.Encode(expected), json.Encode(entity))
+		t.Fail()
+	}
+
+	if v1.Info["name"] != "John" {
+		t.Fatalf("Upcasting modified stored event %v", v1)
+	}
+
+	other := upcasters.Upcast(es.Event{EntityType: "order", Info: es.Info{"name": "x"}})
+	if other.Info["name"] != "x" {
+		t.Fatalf("Unexpected upcast of other entity type %v", other)
+	}
+}
@@ -1,17 +1,100 @@
 package main
 
 import (
-	"fmt"
-	"runtime/debug"
-
-	"github.com/andrew-suprun/legion/json"
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/andrew-suprun/legion/auth"
 	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/gateway"
+	"github.com/andrew-suprun/legion/locale"
+	"github.com/andrew-suprun/legion/persistence/in_memory"
+	"github.com/andrew-suprun/legion/queue/durable"
+	"github.com/andrew-suprun/legion/server"
 )
 
+// Demo server with a single "echo" command:
+//
+//	curl -X POST localhost:8080/commands/echo -d '{"text": "hello"}'
+//
+// Commands can also be sent as {"command_type": "echo", "info": {...}} over WebSocket connected to /connect.
+// Replies are translated with catalogs of LEGION_LOCALES directory to the locale of Accept-Language header.
 func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	flag.Parse()
+
+	if dir := os.Getenv("LEGION_LOCALES"); dir != "" {
+		if err := locale.Default.LoadDir(dir); err != nil {
+			log.Fatalf("Failed to load locales: %v", err)
+		}
+	}
+
+	var options []server.Option
+	if key := os.Getenv("LEGION_JWT_KEY"); key != "" {
+		options = append(options, server.WithAuthenticator(auth.NewJWTAuthenticator([]byte(key))))
+	}
+	connections := gateway.NewConnections()
+	p := in_memory.NewPersistence(entityFactory)
+	options = append(options, server.WithOutbox(p.(durable.Persistence), connections.Deliver))
+	s := server.New(timeService{}, p, commandFactory, options...)
+	connections.Attach(s)
+	s.RunOutbox()
+	g := gateway.New(s, gateway.WithConnections(connections))
+	httpServer := &http.Server{Addr: *addr, Handler: g}
+
+	stopped := make(chan bool)
+	go func() {
+		defer close(stopped)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		g.Drain()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		httpServer.Shutdown(ctx)
+		connections.Close()
+	}()
+
+	log.Printf("Listening on %s", *addr)
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatalf("Server failed: %v", err)
+	}
+	<-stopped
+	s.Shutdown()
+}
+
+type timeService struct{}
+
+func (timeService) Now() time.Time {
+	return time.Now()
+}
+
+type echo struct {
+	Info es.Info
+}
+
+func (echo) CommandType() es.CommandType                 { return "echo" }
+func (echo) Validate(helper server.CommandHelper) error  { return nil }
+func (echo) Authorize(helper server.CommandHelper) error { return nil }
+func (c echo) Handle(helper server.CommandHelper) error {
+	helper.Reply("echo", c.Info)
+	return nil
+}
+
+func commandFactory(cmdType es.CommandType, info es.Info) (server.Command, error) {
+	if cmdType == "echo" {
+		return echo{Info: info}, nil
+	}
+	return nil, errors.NewError(errors.Failure, server.InvalidCommand, "Unknown command.", es.Info{"command_type": cmdType})
+}
 
-	fmt.Println(string(debug.Stack()))
-	fmt.Println("----")
-	fmt.Println(json.Encode(errors.StackTrace()))
+func entityFactory(et es.EntityType, id es.EntityId) (es.Entity, error) {
+	return nil, errors.NewError(errors.Failure, server.UnknownEntityTypeError, "Unknown entity type.", es.Info{"entity_type": et})
 }
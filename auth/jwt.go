@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/server"
+)
+
+// JWTAuthenticator verifies HS256 signed JSON web tokens against a local key.
+//
+// Recognized claims are "sub" (user id), "tenant", "roles", "scope" (space separated),
+// "exp" and "nbf".
+type JWTAuthenticator struct {
+	key         []byte
+	timeService server.TimeService
+	leeway      time.Duration
+}
+
+var _ server.Authenticator = (*JWTAuthenticator)(nil)
+
+type Option func(a *JWTAuthenticator)
+
+// WithTimeService sets the clock tokens expiration is checked against.
+func WithTimeService(timeService server.TimeService) Option {
+	return func(a *JWTAuthenticator) {
+		a.timeService = timeService
+	}
+}
+
+// WithLeeway tolerates clock skew between token issuer and the server.
+func WithLeeway(leeway time.Duration) Option {
+	return func(a *JWTAuthenticator) {
+		a.leeway = leeway
+	}
+}
+
+type systemTime struct{}
+
+func (systemTime) Now() time.Time {
+	return time.Now()
+}
+
+func NewJWTAuthenticator(key []byte, options ...Option) *JWTAuthenticator {
+	a := &JWTAuthenticator{key: key, timeService: systemTime{}}
+	for _, option := range options {
+		option(a)
+	}
+	return a
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+}
+
+type claims struct {
+	Subject   string   `json:"sub"`
+	Tenant    string   `json:"tenant,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+}
+
+var encoding = base64.RawURLEncoding
+
+func (a *JWTAuthenticator) Authenticate(credentials server.Credentials) (server.Principal, error) {
+	parts := strings.Split(credentials.Token, ".")
+	if len(parts) != 3 {
+		return server.Principal{}, unauthorized("Malformed token.")
+	}
+
+	var h header
+	if err := decode(parts[0], &h); err != nil || h.Algorithm != "HS256" {
+		return server.Principal{}, unauthorized("Unsupported token algorithm.")
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, a.sign(parts[0]+"."+parts[1])) {
+		return server.Principal{}, unauthorized("Invalid token signature.")
+	}
+
+	var c claims
+	if err := decode(parts[1], &c); err != nil {
+		return server.Principal{}, unauthorized("Malformed token claims.")
+	}
+	now := a.timeService.Now()
+	if c.ExpiresAt != 0 && !now.Before(time.Unix(c.ExpiresAt, 0).Add(a.leeway)) {
+		return server.Principal{}, unauthorized("Token expired.")
+	}
+	if c.NotBefore != 0 && now.Add(a.leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return server.Principal{}, unauthorized("Token is not valid yet.")
+	}
+	if c.Subject == "" {
+		return server.Principal{}, unauthorized("Token has no subject.")
+	}
+
+	return server.Principal{
+		UserId:   es.EntityId(c.Subject),
+		TenantId: es.TenantId(c.Tenant),
+		Roles:    c.Roles,
+		Scopes:   strings.Fields(c.Scope),
+	}, nil
+}
+
+// Sign issues a token for the principal that expires at the given time; zero time never expires.
+func (a *JWTAuthenticator) Sign(principal server.Principal, expires time.Time) string {
+	c := claims{
+		Subject: string(principal.UserId),
+		Tenant:  string(principal.TenantId),
+		Roles:   principal.Roles,
+		Scope:   strings.Join(principal.Scopes, " "),
+	}
+	if !expires.IsZero() {
+		c.ExpiresAt = expires.Unix()
+	}
+	payload := encode(header{Algorithm: "HS256", Type: "JWT"}) + "." + encode(c)
+	return payload + "." + encoding.EncodeToString(a.sign(payload))
+}
+
+func (a *JWTAuthenticator) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func encode(v interface{}) string {
+	bytes, _ := json.Marshal(v)
+	return encoding.EncodeToString(bytes)
+}
+
+func decode(part string, v interface{}) error {
+	bytes, err := encoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bytes, v)
+}
+
+func unauthorized(desc string) error {
+	return errors.NewError(errors.Failure, errors.Unauthorized, desc)
+}
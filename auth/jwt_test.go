@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/server"
+)
+
+func TestJWTAuthenticator(t *testing.T) {
+	a := NewJWTAuthenticator([]byte("secret"))
+	principal := server.Principal{UserId: "user-1", TenantId: "acme", Roles: []string{"admin"}, Scopes: []string{"read", "write"}}
+
+	authenticated, err := a.Authenticate(server.Credentials{Token: a.Sign(principal, time.Now().Add(time.Hour))})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if authenticated.UserId != "user-1" || authenticated.TenantId != "acme" || !authenticated.HasRole("admin") || !authenticated.HasScope("write") {
+		t.Fatalf("Unexpected principal: %v", authenticated)
+	}
+
+	tokens := map[string]string{
+		"expired":   a.Sign(principal, time.Now().Add(-time.Hour)),
+		"other key": NewJWTAuthenticator([]byte("other")).Sign(principal, time.Time{}),
+		"malformed": "not.a-token",
+	}
+	for name, token := range tokens {
+		_, err := a.Authenticate(server.Credentials{Token: token})
+		if e, ok := err.(errors.Error); !ok || e.Code != errors.Unauthorized {
+			t.Fatalf("Expected %s token to be unauthorized, got %v", name, err)
+		}
+	}
+}
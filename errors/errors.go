@@ -2,6 +2,7 @@ package errors
 
 import (
 	"bytes"
+	stdjson "encoding/json"
 	"runtime/debug"
 	"strings"
 
@@ -9,13 +10,17 @@ import (
 	"github.com/andrew-suprun/legion/json"
 )
 
-// TODO: Ability to chain errors
+// Error is chained to the error that caused it, if any; errors.Is and errors.As
+// of the standard library see the whole chain and errors.Is matches Error by ErrorCode:
+//
+//	if errors.Is(err, server.DatabaseError) { ... }
 type Error struct {
 	Severity    ErrorSeverity `json:"error_severity" bson:"error_severity"`
 	Code        ErrorCode     `json:"error_code" bson:"error_code"`
 	Description string        `json:"description" bson:"description"`
 	Info        es.Info       `json:"info,omitempty" bson:"info,omitempty"`
 	Trace       []string      `json:"stack_trace,omitempty" bson:"stack_trace,omitempty"`
+	Cause       error         `json:"-" bson:"-"`
 }
 type Errors []Error
 
@@ -29,12 +34,35 @@ const (
 
 type ErrorCode string
 
+// Error makes codes targets of errors.Is.
+func (code ErrorCode) Error() string {
+	return string(code)
+}
+
 const (
 	InvalidRequest ErrorCode = "invalid_request"
 	Unauthorized   ErrorCode = "unauthorized"
 )
 
 func NewError(severity ErrorSeverity, code ErrorCode, desc string, info ...es.Info) Error {
+	result := newError(severity, code, desc, info)
+	if severity != Diagnostics {
+		result.Trace = StackTrace()
+	}
+	return result
+}
+
+// Wrap returns a new error caused by the error, e.g. a persistence error that made the service fail.
+func Wrap(cause error, severity ErrorSeverity, code ErrorCode, desc string, info ...es.Info) Error {
+	result := newError(severity, code, desc, info)
+	result.Cause = cause
+	if severity != Diagnostics {
+		result.Trace = StackTrace()
+	}
+	return result
+}
+
+func newError(severity ErrorSeverity, code ErrorCode, desc string, info []es.Info) Error {
 	merged := es.Info{}
 	for _, d := range info {
 		for k, v := range d {
@@ -42,22 +70,67 @@ func NewError(severity ErrorSeverity, code ErrorCode, desc string, info ...es.In
 		}
 	}
 
-	result := Error{
+	return Error{
 		Severity:    severity,
 		Code:        code,
 		Description: desc,
 		Info:        merged,
 	}
+}
 
-	if severity != Diagnostics {
-		result.Trace = StackTrace()
+func (err Error) Error() string {
+	return json.Encode(err)
+}
+
+func (err Error) Unwrap() error {
+	return err.Cause
+}
+
+// Is matches errors with the same code; the target is either ErrorCode or Error.
+func (err Error) Is(target error) bool {
+	switch t := target.(type) {
+	case ErrorCode:
+		return err.Code == t
+	case Error:
+		return t.Code != "" && err.Code == t.Code
 	}
+	return false
+}
 
-	return result
+// fields are fields of Error without its methods.
+type fields Error
+
+// MarshalJSON encodes the chain of causes under "cause"; causes other than Error
+// are encoded with their descriptions only.
+func (err Error) MarshalJSON() ([]byte, error) {
+	encoded := struct {
+		fields
+		Cause interface{} `json:"cause,omitempty"`
+	}{fields: fields(err)}
+	switch cause := err.Cause.(type) {
+	case nil:
+	case Error:
+		encoded.Cause = cause
+	default:
+		encoded.Cause = es.Info{"description": cause.Error()}
+	}
+	return stdjson.Marshal(encoded)
 }
 
-func (err Error) Error() string {
-	return json.Encode(err)
+// UnmarshalJSON decodes causes as Error.
+func (err *Error) UnmarshalJSON(data []byte) error {
+	var decoded struct {
+		fields
+		Cause *Error `json:"cause"`
+	}
+	if e := stdjson.Unmarshal(data, &decoded); e != nil {
+		return e
+	}
+	*err = Error(decoded.fields)
+	if decoded.Cause != nil {
+		err.Cause = *decoded.Cause
+	}
+	return nil
 }
 
 func StackTrace() []string {
@@ -0,0 +1,65 @@
+package errors
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"os"
+	"testing"
+
+	"github.com/andrew-suprun/legion/es"
+)
+
+const databaseError ErrorCode = "database_error"
+
+func TestChain(t *testing.T) {
+	_, cause := os.Open("/no/such/file")
+	persistence := Wrap(cause, Failure, databaseError, "Failed to fetch entity.", es.Info{"entity_id": "a"})
+	service := Wrap(persistence, Failure, InvalidRequest, "Account cannot be opened.")
+
+	if !stderrors.Is(service, InvalidRequest) || !stderrors.Is(service, databaseError) || stderrors.Is(service, Unauthorized) {
+		t.Fatal("Expected errors.Is to match codes of the chain")
+	}
+	if !stderrors.Is(service, NewError(Diagnostics, databaseError, "Other description.")) {
+		t.Fatal("Expected errors.Is to match Error by code")
+	}
+	if !stderrors.Is(service, os.ErrNotExist) {
+		t.Fatal("Expected errors.Is to reach the cause")
+	}
+	var pathErr *os.PathError
+	if !stderrors.As(service, &pathErr) || pathErr.Path != "/no/such/file" {
+		t.Fatal("Expected errors.As to reach the cause")
+	}
+	if unwrapped, ok := stderrors.Unwrap(service).(Error); !ok || unwrapped.Description != persistence.Description || len(service.Trace) == 0 {
+		t.Fatalf("Unexpected wrapped error %v", service)
+	}
+	if NewError(Failure, InvalidRequest, "No cause.").Unwrap() != nil {
+		t.Fatal("Expected no cause")
+	}
+}
+
+func TestChainJSON(t *testing.T) {
+	cause := Wrap(stderrors.New("connection refused"), Failure, databaseError, "Failed to fetch entity.")
+	err := Wrap(cause, Failure, InvalidRequest, "Account cannot be opened.", es.Info{"name": "Savings"})
+
+	var decoded Error
+	if e := json.Unmarshal([]byte(err.Error()), &decoded); e != nil {
+		t.Fatal(e)
+	}
+	if decoded.Code != InvalidRequest || decoded.Info["name"] != "Savings" || len(decoded.Trace) == 0 {
+		t.Fatalf("Unexpected decoded error %v", decoded)
+	}
+	decodedCause, ok := decoded.Cause.(Error)
+	if !ok || decodedCause.Code != databaseError || decodedCause.Description != "Failed to fetch entity." {
+		t.Fatalf("Unexpected decoded cause %v", decoded.Cause)
+	}
+	if root, ok := decodedCause.Cause.(Error); !ok || root.Description != "connection refused" {
+		t.Fatalf("Unexpected decoded root cause %v", decodedCause.Cause)
+	}
+
+	encoded, _ := json.Marshal(NewError(Diagnostics, InvalidRequest, "No cause."))
+	var fields map[string]interface{}
+	json.Unmarshal(encoded, &fields)
+	if _, ok := fields["cause"]; ok {
+		t.Fatalf("Expected no cause in %s", encoded)
+	}
+}
@@ -11,14 +11,30 @@ import (
 type EventId string
 type CommandType string
 
+// Event belongs to the chain of commands identified by CorrelationId;
+// CausationId is always the id of the event that triggered the command, if any, never a command id.
 type Event struct {
-	EventId     EventId     `json:"event_id" bson:"event_id"`
-	CommandType CommandType `json:"command_type" bson:"command_type"`
-	CommandId   EntityId    `json:"command_id" bson:"command_id"`
-	EntityType  EntityType  `json:"entity_type" bson:"entity_type"`
-	EntityId    EntityId    `json:"entity_id" bson:"entity_id"`
-	Timestamp   time.Time   `json:"timestamp" bson:"timestamp"`
-	Info        Info        `json:"info,omitempty" bson:"info,omitempty"`
+	EventId       EventId     `json:"event_id" bson:"event_id"`
+	CommandType   CommandType `json:"command_type" bson:"command_type"`
+	CommandId     EntityId    `json:"command_id" bson:"command_id"`
+	EntityType    EntityType  `json:"entity_type" bson:"entity_type"`
+	EntityId      EntityId    `json:"entity_id" bson:"entity_id"`
+	CorrelationId EntityId    `json:"correlation_id,omitempty" bson:"correlation_id,omitempty"`
+	CausationId   EntityId    `json:"causation_id,omitempty" bson:"causation_id,omitempty"`
+	Timestamp     time.Time   `json:"timestamp" bson:"timestamp"`
+	Metadata      Metadata    `json:"metadata" bson:"metadata"`
+	Info          Info        `json:"info,omitempty" bson:"info,omitempty"`
+}
+
+type TenantId string
+
+// Metadata records who caused the event and how to interpret its Info.
+type Metadata struct {
+	Principal     EntityId `json:"principal,omitempty" bson:"principal,omitempty"`
+	TenantId      TenantId `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+	Source        string   `json:"source,omitempty" bson:"source,omitempty"`
+	AppVersion    string   `json:"app_version,omitempty" bson:"app_version,omitempty"`
+	SchemaVersion int      `json:"schema_version,omitempty" bson:"schema_version,omitempty"`
 }
 
 func (e Event) String() string {
@@ -39,6 +55,12 @@ type Entity interface {
 }
 type Entities []Entity
 
+// VersionedEntity is implemented by entities whose event schema has changed over time.
+type VersionedEntity interface {
+	Entity
+	SchemaVersion() int
+}
+
 func NewEventId() EventId {
 	return EventId(NewEntityId())
 }
@@ -51,9 +73,11 @@ func NewEntityId() EntityId {
 
 type MessageType string
 type Message struct {
-	ConnectionId EntityId    `json:"connection_id"`
-	MessageType  MessageType `json:"message_type"`
-	Info         Info        `json:"info,omitempty"`
+	ConnectionId  EntityId    `json:"connection_id"`
+	MessageType   MessageType `json:"message_type"`
+	CorrelationId EntityId    `json:"correlation_id,omitempty"`
+	CausationId   EntityId    `json:"causation_id,omitempty"`
+	Info          Info        `json:"info,omitempty"`
 }
 
 func (m Message) String() string {
@@ -0,0 +1,364 @@
+package gateway
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/locale"
+	"github.com/andrew-suprun/legion/server"
+)
+
+// Envelope types sent to WebSocket clients.
+const (
+	EnvelopeConnected = "connected"
+	EnvelopeMessage   = "message"
+	EnvelopeResult    = "result"
+)
+
+// Envelope is a frame sent to WebSocket clients: the assigned connection id and resume token once connected,
+// messages addressed to the connection and results of commands sent over the connection.
+type Envelope struct {
+	Type         string      `json:"type"`
+	ConnectionId es.EntityId `json:"connection_id,omitempty"`
+	ResumeToken  string      `json:"resume_token,omitempty"`
+	RequestId    string      `json:"request_id,omitempty"`
+	Message      *es.Message `json:"message,omitempty"`
+	Result       *Response   `json:"result,omitempty"`
+}
+
+// CommandRequest is a frame sent by WebSocket clients; RequestId is echoed in the result.
+// Retries of the request should carry the same IdempotencyKey. Locale overrides locale of the connection.
+type CommandRequest struct {
+	RequestId      string         `json:"request_id,omitempty"`
+	IdempotencyKey string         `json:"idempotency_key,omitempty"`
+	Locale         string         `json:"locale,omitempty"`
+	CommandType    es.CommandType `json:"command_type"`
+	Info           es.Info        `json:"info,omitempty"`
+}
+
+// Connections accepts WebSocket connections, serves commands sent over them
+// and routes messages to the connections they are addressed to. Messages are routed
+// once committed; Deliver has to be the dispatcher of the server outbox:
+//
+//	connections := gateway.NewConnections()
+//	s := server.New(ts, p, cf, server.WithOutbox(p.(durable.Persistence), connections.Deliver))
+//	connections.Attach(s)
+//	s.RunOutbox()
+//
+// Clients that drop the connection without the close handshake can reconnect with
+// ?connection_id=<id>&resume_token=<token> within the reconnect window and receive envelopes
+// buffered meanwhile; the resume token is sent only to the client in the connected envelope.
+// Closing with the normal close code ends the connection right away.
+type Connections struct {
+	server          *server.Server
+	reconnectWindow time.Duration
+	bufferSize      int
+	maxMessageSize  int
+
+	lock     sync.Mutex
+	sessions map[es.EntityId]*session
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+type session struct {
+	connId      es.EntityId
+	resumeToken string
+	principal   server.Principal
+	conn        *wsConn
+	buffer      []Envelope
+	expiry      *time.Timer
+}
+
+type ConnectionsOption func(c *Connections)
+
+// WithReconnectWindow sets how long envelopes of dropped connections are buffered.
+func WithReconnectWindow(window time.Duration) ConnectionsOption {
+	return func(c *Connections) {
+		c.reconnectWindow = window
+	}
+}
+
+// WithBufferSize limits envelopes buffered per dropped connection; the oldest ones are dropped first.
+func WithBufferSize(size int) ConnectionsOption {
+	return func(c *Connections) {
+		c.bufferSize = size
+	}
+}
+
+func WithMaxMessageSize(size int) ConnectionsOption {
+	return func(c *Connections) {
+		c.maxMessageSize = size
+	}
+}
+
+func NewConnections(options ...ConnectionsOption) *Connections {
+	c := &Connections{
+		reconnectWindow: 30 * time.Second,
+		bufferSize:      100,
+		maxMessageSize:  1 << 20,
+		sessions:        map[es.EntityId]*session{},
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+func (c *Connections) Attach(s *server.Server) {
+	c.server = s
+}
+
+// WithConnections serves WebSocket connections at /connect.
+func WithConnections(connections *Connections) Option {
+	return func(g *Gateway) {
+		g.mux.Handle("/connect", connections)
+	}
+}
+
+// ServeHTTP upgrades the request to a WebSocket connection. The bearer token can be passed
+// either in the Authorization header or as ?token=, the locale as Accept-Language header or ?locale=.
+// Commands are served for the tenant of the principal; X-Tenant-Id header or ?tenant=, if given, must match it.
+func (c *Connections) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	credentials := server.Credentials{Token: query.Get("token")}
+	if authorization := r.Header.Get("Authorization"); len(authorization) > 7 && authorization[:7] == "Bearer " {
+		credentials.Token = authorization[7:]
+	}
+	var principal server.Principal
+	if credentials.Token != "" {
+		var err error
+		if principal, err = c.server.Authenticate(credentials); err != nil {
+			writeFailure(w, StatusCode(err), err)
+			return
+		}
+	}
+	requested := es.TenantId(r.Header.Get(TenantHeader))
+	if requested == "" {
+		requested = es.TenantId(query.Get("tenant"))
+	}
+	tenantId, err := tenantOf(principal, requested)
+	if err != nil {
+		writeFailure(w, StatusCode(err), err)
+		return
+	}
+
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		writeFailure(w, http.StatusServiceUnavailable, errors.NewError(errors.Failure, server.ServerError, "Server is shutting down."))
+		return
+	}
+	c.wg.Add(1)
+	defer c.wg.Done()
+	s, resumed := c.sessions[es.EntityId(query.Get("connection_id"))]
+	if resumed && (s.conn != nil || s.principal.UserId != principal.UserId ||
+		subtle.ConstantTimeCompare([]byte(s.resumeToken), []byte(query.Get("resume_token"))) != 1) {
+		c.lock.Unlock()
+		writeFailure(w, http.StatusConflict, errors.NewError(errors.Failure, errors.InvalidRequest, "Connection cannot be resumed.", es.Info{"connection_id": s.connId}))
+		return
+	}
+	c.lock.Unlock()
+
+	conn, err := upgrade(w, r, c.maxMessageSize)
+	if err != nil {
+		return
+	}
+
+	c.lock.Lock()
+	if !resumed {
+		s = &session{connId: es.NewEntityId(), resumeToken: newResumeToken(), principal: principal}
+		c.sessions[s.connId] = s
+	}
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+	c.lock.Unlock()
+
+	c.attach(s, conn)
+	ctx := server.WithMetadata(context.Background(), es.Metadata{TenantId: tenantId, Source: r.RemoteAddr})
+	ctx = server.WithPrincipal(ctx, principal)
+	if preferred := negotiateLocale(r); preferred != "" {
+		ctx = server.WithLocale(ctx, preferred)
+	}
+
+	var closeErr CloseError
+	for {
+		opcode, payload, err := conn.readMessage()
+		if err != nil {
+			closeErr, _ = err.(CloseError)
+			break
+		}
+		if opcode != opText {
+			conn.close(CloseUnsupportedData, "Expected JSON text messages.")
+			continue
+		}
+		var request CommandRequest
+		if err := json.Unmarshal(payload, &request); err != nil || request.CommandType == "" {
+			failure := errors.NewError(errors.Failure, errors.InvalidRequest, "Expected command request.")
+			failure.Trace = nil
+			c.send(s, Envelope{Type: EnvelopeResult, RequestId: request.RequestId, Result: &Response{Failure: &failure}})
+			continue
+		}
+		c.wg.Add(1)
+		go c.serve(ctx, s, request)
+	}
+	conn.closeConn()
+	c.detach(s, conn, closeErr.Code == CloseNormal)
+}
+
+func (c *Connections) serve(ctx context.Context, s *session, request CommandRequest) {
+	defer c.wg.Done()
+	if request.IdempotencyKey != "" {
+		ctx = server.WithIdempotencyKey(ctx, request.IdempotencyKey)
+	}
+	if request.Locale != "" {
+		ctx = server.WithLocale(ctx, locale.Negotiate(request.Locale))
+	}
+	result := (<-c.server.ServeContext(ctx, s.connId, request.CommandType, request.Info)).(*server.ServiceResult)
+	response := &Response{
+		CommandId:     result.CommandId,
+		CorrelationId: result.CorrelationId,
+		Diagnostics:   result.Diagnostics,
+		Duplicate:     result.Duplicate,
+	}
+	if result.Failure != nil {
+		failure := asError(result.Failure)
+		response.Failure = &failure
+	}
+	c.send(s, Envelope{Type: EnvelopeResult, RequestId: request.RequestId, Result: response})
+}
+
+// Deliver routes messages to connections they are addressed to, buffering them for dropped connections.
+// Messages to unknown connections are discarded.
+func (c *Connections) Deliver(messages es.Messages) error {
+	for i := range messages {
+		c.lock.Lock()
+		s, ok := c.sessions[messages[i].ConnectionId]
+		c.lock.Unlock()
+		if ok {
+			c.send(s, Envelope{Type: EnvelopeMessage, Message: &messages[i]})
+		}
+	}
+	return nil
+}
+
+// attach flushes envelopes buffered while the client was away and announces the connection id.
+func (c *Connections) attach(s *session, conn *wsConn) {
+	c.lock.Lock()
+	buffered := s.buffer
+	s.buffer = nil
+	s.conn = conn
+	c.lock.Unlock()
+
+	c.send(s, Envelope{Type: EnvelopeConnected, ConnectionId: s.connId, ResumeToken: s.resumeToken})
+	for _, envelope := range buffered {
+		c.send(s, envelope)
+	}
+}
+
+func (c *Connections) send(s *session, envelope Envelope) {
+	c.lock.Lock()
+	conn := s.conn
+	if conn == nil {
+		c.bufferLocked(s, envelope)
+		c.lock.Unlock()
+		return
+	}
+	c.lock.Unlock()
+
+	payload, _ := json.Marshal(envelope)
+	if err := conn.writeMessage(opText, payload); err != nil {
+		c.lock.Lock()
+		c.bufferLocked(s, envelope)
+		c.lock.Unlock()
+		c.detach(s, conn, false)
+	}
+}
+
+func (c *Connections) bufferLocked(s *session, envelope Envelope) {
+	if _, live := c.sessions[s.connId]; !live {
+		return
+	}
+	s.buffer = append(s.buffer, envelope)
+	if len(s.buffer) > c.bufferSize {
+		s.buffer = s.buffer[len(s.buffer)-c.bufferSize:]
+	}
+}
+
+// detach keeps the session for the reconnect window unless the client closed the connection normally.
+func (c *Connections) detach(s *session, conn *wsConn, final bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if s.conn != conn {
+		return
+	}
+	s.conn = nil
+	if final || c.closed {
+		c.endLocked(s)
+		return
+	}
+	var expiry *time.Timer
+	expiry = time.AfterFunc(c.reconnectWindow, func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		if s.expiry == expiry {
+			c.endLocked(s)
+		}
+	})
+	s.expiry = expiry
+}
+
+func newResumeToken() string {
+	var buf [24]byte
+	rand.Read(buf[:])
+	return base64.RawURLEncoding.EncodeToString(buf[:])
+}
+
+func (c *Connections) endLocked(s *session) {
+	delete(c.sessions, s.connId)
+	s.buffer = nil
+}
+
+// Len returns the number of connections including dropped ones within the reconnect window.
+func (c *Connections) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.sessions)
+}
+
+// Close tells all the clients that the server is going away and waits for connections to close.
+func (c *Connections) Close() {
+	c.lock.Lock()
+	c.closed = true
+	var conns []*wsConn
+	for _, s := range c.sessions {
+		if s.conn != nil {
+			conns = append(conns, s.conn)
+		}
+		if s.expiry != nil {
+			s.expiry.Stop()
+		}
+	}
+	c.lock.Unlock()
+
+	for _, conn := range conns {
+		conn.close(CloseGoingAway, "Server is shutting down.")
+	}
+	c.wg.Wait()
+
+	c.lock.Lock()
+	for _, s := range c.sessions {
+		c.endLocked(s)
+	}
+	c.lock.Unlock()
+}
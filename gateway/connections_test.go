@@ -0,0 +1,262 @@
+package gateway
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/persistence/in_memory"
+	"github.com/andrew-suprun/legion/queue/durable"
+	"github.com/andrew-suprun/legion/server"
+)
+
+// dial opens a client connection; used by tests and tools talking to the gateway.
+func dial(addr, path string, header http.Header) (*wsConn, *http.Response, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	request, _ := http.NewRequest(http.MethodGet, "http://"+addr+path, nil)
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	request.Header.Set("Connection", "Upgrade")
+	request.Header.Set("Upgrade", "websocket")
+	request.Header.Set("Sec-WebSocket-Version", "13")
+	request.Header.Set("Sec-WebSocket-Key", key)
+	if err := request.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols || response.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		conn.Close()
+		return nil, response, fmt.Errorf("handshake failed: %s", response.Status)
+	}
+	return &wsConn{conn: conn, reader: reader, client: true}, response, nil
+}
+
+type notify struct {
+	to   es.EntityId
+	text string
+}
+
+func (notify) CommandType() es.CommandType                 { return "notify" }
+func (notify) Validate(helper server.CommandHelper) error  { return nil }
+func (notify) Authorize(helper server.CommandHelper) error { return nil }
+func (c notify) Handle(helper server.CommandHelper) error {
+	helper.SendMessage(es.Message{ConnectionId: c.to, MessageType: "notification", Info: es.Info{"text": c.text}})
+	return nil
+}
+
+func connectionsCommandFactory(cmdType es.CommandType, info es.Info) (server.Command, error) {
+	if cmdType == "notify" {
+		to, _ := info["to"].(string)
+		text, _ := info["text"].(string)
+		return notify{to: es.EntityId(to), text: text}, nil
+	}
+	return commandFactory(cmdType, info)
+}
+
+type client struct {
+	t           *testing.T
+	conn        *wsConn
+	id          es.EntityId
+	resumeToken string
+}
+
+// connect opens a new connection or resumes the dropped connection of the client.
+func connect(t *testing.T, addr string, dropped *client) *client {
+	path := "/connect"
+	if dropped != nil {
+		path += "?connection_id=" + string(dropped.id) + "&resume_token=" + dropped.resumeToken
+	}
+	conn, _, err := dial(addr, path, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	c := &client{t: t, conn: conn}
+	envelope := c.receive()
+	if envelope.Type != EnvelopeConnected || envelope.ConnectionId == "" || envelope.ResumeToken == "" {
+		t.Fatalf("Expected connection id, got %v", envelope)
+	}
+	c.id = envelope.ConnectionId
+	c.resumeToken = envelope.ResumeToken
+	return c
+}
+
+func (c *client) send(requestId string, cmdType es.CommandType, info es.Info) {
+	payload, _ := json.Marshal(CommandRequest{RequestId: requestId, CommandType: cmdType, Info: info})
+	if err := c.conn.writeMessage(opText, payload); err != nil {
+		c.t.Fatalf("Failed to send: %v", err)
+	}
+}
+
+func (c *client) receive() Envelope {
+	c.conn.conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, payload, err := c.conn.readMessage()
+	if err != nil {
+		c.t.Fatalf("Failed to receive: %v", err)
+	}
+	var envelope Envelope
+	json.Unmarshal(payload, &envelope)
+	return envelope
+}
+
+// receiveAll returns the next n envelopes keyed by type; the order of results and messages is not defined.
+func (c *client) receiveAll(n int) map[string]Envelope {
+	envelopes := map[string]Envelope{}
+	for i := 0; i < n; i++ {
+		envelope := c.receive()
+		envelopes[envelope.Type] = envelope
+	}
+	return envelopes
+}
+
+func newConnectionsServer(options ...ConnectionsOption) (*Connections, *httptest.Server) {
+	connections := NewConnections(options...)
+	p := in_memory.NewPersistence(nil)
+	s := server.New(testTimeService{}, p, connectionsCommandFactory, server.WithOutbox(p.(durable.Persistence), connections.Deliver))
+	connections.Attach(s)
+	s.RunOutbox()
+	return connections, httptest.NewServer(New(s, WithConnections(connections)))
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	for deadline := time.Now().Add(time.Second); !condition(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out.")
+		}
+	}
+}
+
+func TestConnectionCommands(t *testing.T) {
+	connections, httpServer := newConnectionsServer()
+	defer httpServer.Close()
+	addr := strings.TrimPrefix(httpServer.URL, "http://")
+
+	ann := connect(t, addr, nil)
+	bob := connect(t, addr, nil)
+
+	ann.send("1", "greet", es.Info{"name": "Ann"})
+	envelopes := ann.receiveAll(2)
+	if message := envelopes[EnvelopeMessage].Message; message == nil || message.Info["text"] != "Hello, Ann" {
+		t.Fatalf("Unexpected envelopes %v", envelopes)
+	}
+	if result := envelopes[EnvelopeResult]; result.RequestId != "1" || result.Result.Failure != nil {
+		t.Fatalf("Unexpected result %v", result)
+	}
+
+	ann.send("2", "greet", nil)
+	if result := ann.receive(); result.Type != EnvelopeResult || result.Result.Failure == nil {
+		t.Fatalf("Expected failed result, got %v", result)
+	}
+
+	ann.send("3", "notify", es.Info{"to": string(bob.id), "text": "Hi Bob"})
+	ann.receive()
+	if envelope := bob.receive(); envelope.Message == nil || envelope.Message.Info["text"] != "Hi Bob" {
+		t.Fatalf("Expected notification, got %v", envelope)
+	}
+
+	ann.conn.close(CloseNormal, "")
+	if _, _, err := ann.conn.readMessage(); closeCode(err) != CloseNormal {
+		t.Fatalf("Expected close handshake to complete, got %v", err)
+	}
+	waitFor(t, func() bool { return connections.Len() == 1 })
+
+	closed := make(chan bool)
+	go func() {
+		connections.Close()
+		close(closed)
+	}()
+	bob.conn.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := bob.conn.readMessage(); closeCode(err) != CloseGoingAway {
+		t.Fatalf("Expected server to go away, got %v", err)
+	}
+	<-closed
+	if connections.Len() != 0 {
+		t.Fatalf("Expected all connections to be closed.")
+	}
+}
+
+func closeCode(err error) CloseCode {
+	closeErr, _ := err.(CloseError)
+	return closeErr.Code
+}
+
+func TestConnectionBuffering(t *testing.T) {
+	connections, httpServer := newConnectionsServer(WithReconnectWindow(50 * time.Millisecond))
+	defer httpServer.Close()
+	defer connections.Close()
+	addr := strings.TrimPrefix(httpServer.URL, "http://")
+
+	ann := connect(t, addr, nil)
+	bob := connect(t, addr, nil)
+	bob.conn.closeConn()
+	detached := func() bool {
+		connections.lock.Lock()
+		defer connections.lock.Unlock()
+		return connections.sessions[bob.id].conn == nil
+	}
+	waitFor(t, detached)
+
+	ann.send("1", "notify", es.Info{"to": string(bob.id), "text": "Missed you"})
+	ann.receive()
+
+	if _, response, err := dial(addr, "/connect?connection_id="+string(bob.id), nil); err == nil || response.StatusCode != http.StatusConflict {
+		t.Fatalf("Expected connection not to be resumed without resume token, got %v", err)
+	}
+	if _, response, err := dial(addr, "/connect?connection_id="+string(bob.id)+"&resume_token="+ann.resumeToken, nil); err == nil || response.StatusCode != http.StatusConflict {
+		t.Fatalf("Expected connection not to be resumed with resume token of another connection, got %v", err)
+	}
+	bob = connect(t, addr, bob)
+	if envelope := bob.receive(); envelope.Message == nil || envelope.Message.Info["text"] != "Missed you" {
+		t.Fatalf("Expected buffered notification, got %v", envelope)
+	}
+
+	bob.conn.closeConn()
+	waitFor(t, func() bool { return connections.Len() == 1 })
+	if _, _, err := dial(addr, "/connect?connection_id="+string(bob.id), nil); err != nil {
+		t.Fatalf("Expected new connection after reconnect window, got %v", err)
+	}
+}
+
+func TestFrameLimits(t *testing.T) {
+	serverEnd, clientEnd := net.Pipe()
+	defer serverEnd.Close()
+	defer clientEnd.Close()
+	serverConn := &wsConn{conn: serverEnd, reader: bufio.NewReader(serverEnd), maxMessageSize: 100}
+	clientConn := &wsConn{conn: clientEnd, reader: bufio.NewReader(clientEnd), client: true}
+
+	go func() {
+		clientConn.writeFrame(opPing, []byte("ping"))
+		if _, op, payload, _ := clientConn.readFrame(); op != opPong || string(payload) != "ping" {
+			t.Errorf("Expected pong, got %x %q", op, payload)
+		}
+		// the pipe is synchronous, the server closes the connection before reading the whole frame
+		go clientConn.writeFrame(opText, []byte(strings.Repeat("x", 101)))
+		clientConn.readFrame()
+	}()
+
+	if _, _, err := serverConn.readMessage(); closeCode(err) != CloseMessageTooBig {
+		t.Fatalf("Expected message to be too big, got %v", err)
+	}
+}
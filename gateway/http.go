@@ -0,0 +1,258 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/locale"
+	"github.com/andrew-suprun/legion/server"
+)
+
+const (
+	commandsPath = "/commands/"
+
+	// Headers recognized by the gateway; the bearer token is passed in the standard Authorization header.
+	TenantHeader      = "X-Tenant-Id"
+	ConnectionHeader  = "X-Connection-Id"
+	IdempotencyHeader = "Idempotency-Key"
+)
+
+// ReadinessCheck reports why the service cannot serve commands yet, e.g. the database is unreachable.
+type ReadinessCheck func() error
+
+// Gateway serves commands posted as JSON to /commands/{type}, /health for liveness
+// and /ready for readiness probes.
+type Gateway struct {
+	server   *server.Server
+	checks   []ReadinessCheck
+	draining int32
+	mux      *http.ServeMux
+}
+
+type Option func(g *Gateway)
+
+func WithReadinessCheck(check ReadinessCheck) Option {
+	return func(g *Gateway) {
+		g.checks = append(g.checks, check)
+	}
+}
+
+func New(s *server.Server, options ...Option) *Gateway {
+	g := &Gateway{server: s, mux: http.NewServeMux()}
+	for _, option := range options {
+		option(g)
+	}
+	g.mux.HandleFunc(commandsPath, g.serveCommand)
+	g.mux.HandleFunc("/health", g.serveHealth)
+	g.mux.HandleFunc("/ready", g.serveReady)
+	return g
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mux.ServeHTTP(w, r)
+}
+
+// Drain makes the gateway report it is not ready, so that load balancers stop routing to it before shutdown.
+func (g *Gateway) Drain() {
+	atomic.StoreInt32(&g.draining, 1)
+}
+
+// Response is the body of command responses.
+type Response struct {
+	CommandId     es.EntityId   `json:"command_id,omitempty"`
+	CorrelationId es.EntityId   `json:"correlation_id,omitempty"`
+	Messages      es.Messages   `json:"messages,omitempty"`
+	Diagnostics   errors.Errors `json:"diagnostics,omitempty"`
+	Duplicate     bool          `json:"duplicate,omitempty"`
+	Failure       *errors.Error `json:"failure,omitempty"`
+}
+
+func (g *Gateway) serveCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeFailure(w, http.StatusMethodNotAllowed, errors.NewError(errors.Failure, errors.InvalidRequest, "Commands have to be posted."))
+		return
+	}
+	cmdType := es.CommandType(strings.TrimPrefix(r.URL.Path, commandsPath))
+	if cmdType == "" || strings.Contains(string(cmdType), "/") {
+		writeFailure(w, http.StatusNotFound, errors.NewError(errors.Failure, errors.InvalidRequest, "Expected /commands/{type}.", es.Info{"path": r.URL.Path}))
+		return
+	}
+
+	var info es.Info
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+			writeFailure(w, http.StatusBadRequest, errors.NewError(errors.Failure, errors.InvalidRequest, "Request body is not a JSON object.", es.Info{"error": err.Error()}))
+			return
+		}
+	}
+
+	ctx, err := g.requestContext(r)
+	if err != nil {
+		writeFailure(w, StatusCode(err), err)
+		return
+	}
+	connId := es.EntityId(r.Header.Get(ConnectionHeader))
+	if connId == "" {
+		connId = es.NewEntityId()
+	}
+
+	result := (<-g.server.ServeContext(ctx, connId, cmdType, info)).(*server.ServiceResult)
+	response := Response{
+		CommandId:     result.CommandId,
+		CorrelationId: result.CorrelationId,
+		Messages:      messagesTo(result.Messages, connId),
+		Diagnostics:   result.Diagnostics,
+		Duplicate:     result.Duplicate,
+	}
+	status := http.StatusOK
+	if result.Failure != nil {
+		status = StatusCode(result.Failure)
+		failure := asError(result.Failure)
+		response.Failure = &failure
+	}
+	writeJSON(w, status, response)
+}
+
+// messagesTo returns the messages addressed to the connection of the request;
+// messages to other connections are delivered to them by the outbox or their websockets.
+func messagesTo(messages es.Messages, connId es.EntityId) (result es.Messages) {
+	for _, message := range messages {
+		if message.ConnectionId == connId {
+			result = append(result, message)
+		}
+	}
+	return result
+}
+
+// requestContext sets tenant, principal, source, locale and idempotency key of the request.
+// Requests without bearer token are served for the anonymous principal, never for the principal
+// of the connection named in X-Connection-Id.
+func (g *Gateway) requestContext(r *http.Request) (context.Context, error) {
+	var principal server.Principal
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		if !strings.HasPrefix(authorization, "Bearer ") {
+			return nil, errors.NewError(errors.Failure, errors.Unauthorized, "Expected bearer token.")
+		}
+		var err error
+		principal, err = g.server.Authenticate(server.Credentials{Token: strings.TrimPrefix(authorization, "Bearer ")})
+		if err != nil {
+			return nil, err
+		}
+	}
+	tenantId, err := tenantOf(principal, es.TenantId(r.Header.Get(TenantHeader)))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := server.WithMetadata(r.Context(), es.Metadata{TenantId: tenantId, Source: r.RemoteAddr})
+	ctx = server.WithPrincipal(ctx, principal)
+	if key := r.Header.Get(IdempotencyHeader); key != "" {
+		ctx = server.WithIdempotencyKey(ctx, key)
+	}
+	if preferred := negotiateLocale(r); preferred != "" {
+		ctx = server.WithLocale(ctx, preferred)
+	}
+	return ctx, nil
+}
+
+// tenantOf returns the tenant of the principal; the tenant requested by the caller
+// is only accepted if it is the tenant of the principal.
+func tenantOf(principal server.Principal, requested es.TenantId) (es.TenantId, error) {
+	if requested != "" && requested != principal.TenantId {
+		return "", errors.NewError(errors.Failure, errors.Unauthorized, "Principal does not belong to the tenant.", es.Info{
+			"user_id":   principal.UserId,
+			"tenant_id": requested,
+		})
+	}
+	return principal.TenantId, nil
+}
+
+// negotiateLocale picks locale of the request from ?locale= or Accept-Language header.
+func negotiateLocale(r *http.Request) string {
+	preferred := locale.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if requested := r.URL.Query().Get("locale"); requested != "" {
+		preferred = []string{requested}
+	}
+	if len(preferred) == 0 {
+		return ""
+	}
+	return locale.Negotiate(preferred...)
+}
+
+func (g *Gateway) serveHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, es.Info{"status": "ok"})
+}
+
+func (g *Gateway) serveReady(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&g.draining) == 1 {
+		writeJSON(w, http.StatusServiceUnavailable, es.Info{"status": "draining"})
+		return
+	}
+	for _, check := range g.checks {
+		if err := check(); err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, es.Info{"status": "not_ready", "error": err.Error()})
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, es.Info{"status": "ready"})
+}
+
+// StatusCode maps command failures to HTTP status codes.
+func StatusCode(err error) int {
+	e, ok := err.(errors.Error)
+	if !ok {
+		return http.StatusInternalServerError
+	}
+	switch e.Code {
+	case errors.Unauthorized:
+		// denials of authenticated principals name the user
+		if userId, _ := e.Info["user_id"].(es.EntityId); userId != "" {
+			return http.StatusForbidden
+		}
+		return http.StatusUnauthorized
+	case errors.InvalidRequest, server.InvalidCommand:
+		return http.StatusBadRequest
+	case server.DatabaseError, server.ServerError:
+		return http.StatusInternalServerError
+	}
+	switch e.Severity {
+	case errors.Diagnostics:
+		return http.StatusOK
+	case errors.Failure:
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// asError keeps internals of the server, such as panics, stack traces and causes other than errors.Error,
+// out of responses.
+func asError(err error) errors.Error {
+	e, ok := err.(errors.Error)
+	if !ok {
+		e = errors.NewError(errors.Alert, server.ServerError, "Internal server error.")
+	}
+	e.Trace = nil
+	if _, ok := e.Cause.(errors.Error); ok {
+		e.Cause = asError(e.Cause)
+	} else {
+		e.Cause = nil
+	}
+	return e
+}
+
+func writeFailure(w http.ResponseWriter, status int, err error) {
+	failure := asError(err)
+	writeJSON(w, status, Response{Failure: &failure})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
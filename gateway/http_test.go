@@ -0,0 +1,239 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	legionErrors "github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/locale"
+	"github.com/andrew-suprun/legion/server"
+)
+
+type testTimeService struct{}
+
+func (testTimeService) Now() time.Time {
+	return time.Now()
+}
+
+type testPersistence struct{}
+
+func (p *testPersistence) PersistEvent(event es.Event)      {}
+func (p *testPersistence) PersistEvents(events ...es.Event) {}
+func (p *testPersistence) FetchEntity(et es.EntityType, id es.EntityId) (es.Entity, error) {
+	return nil, nil
+}
+func (p *testPersistence) FetchEntityAt(et es.EntityType, id es.EntityId, timestamp time.Time) (es.Entity, error) {
+	return nil, nil
+}
+func (p *testPersistence) FetchCausalChain(correlationId es.EntityId) (es.Events, error) {
+	return nil, nil
+}
+func (p *testPersistence) FetchEvents(filter server.EventFilter) (es.Events, error) {
+	return nil, nil
+}
+func (p *testPersistence) Tenant(tenantId es.TenantId) server.Persistence {
+	return p
+}
+
+type greet struct {
+	name   string
+	notify es.EntityId
+}
+
+func (greet) CommandType() es.CommandType { return "greet" }
+func (c greet) Validate(helper server.CommandHelper) error {
+	if c.name == "" {
+		return legionErrors.NewError(legionErrors.Failure, legionErrors.InvalidRequest, "Name is required.")
+	}
+	return nil
+}
+func (greet) Authorize(helper server.CommandHelper) error { return nil }
+func (c greet) Handle(helper server.CommandHelper) error {
+	if c.name == "nobody" {
+		return legionErrors.NewError(legionErrors.Failure, "no_such_person", "Nobody to greet.")
+	}
+	helper.Reply("greeting", es.Info{"text": "Hello, " + c.name, "user_id": helper.Principal().UserId})
+	if c.notify != "" {
+		helper.SendMessage(es.Message{ConnectionId: c.notify, MessageType: "greeted", Info: es.Info{"name": c.name}})
+	}
+	return nil
+}
+
+func commandFactory(cmdType es.CommandType, info es.Info) (server.Command, error) {
+	if cmdType != "greet" {
+		return nil, legionErrors.NewError(legionErrors.Failure, server.InvalidCommand, "Unknown command.")
+	}
+	name, _ := info["name"].(string)
+	notify, _ := info["notify"].(string)
+	return greet{name: name, notify: es.EntityId(notify)}, nil
+}
+
+func TestCommands(t *testing.T) {
+	g := New(server.New(testTimeService{}, &testPersistence{}, commandFactory))
+
+	post := func(path, body string) (int, Response) {
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		var response Response
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	status, response := post("/commands/greet", `{"name": "Ann"}`)
+	if status != http.StatusOK || len(response.Messages) != 1 || response.Messages[0].Info["text"] != "Hello, Ann" {
+		t.Fatalf("Unexpected response %d: %v", status, response)
+	}
+	status, response = post("/commands/greet", `{"name": "Ann", "notify": "other"}`)
+	if status != http.StatusOK || len(response.Messages) != 1 || response.Messages[0].MessageType != "greeting" {
+		t.Fatalf("Expected only the reply to the requesting connection, got %d: %v", status, response)
+	}
+
+	cases := []struct {
+		path, body string
+		status     int
+	}{
+		{"/commands/greet", `{}`, http.StatusBadRequest},
+		{"/commands/greet", `[1]`, http.StatusBadRequest},
+		{"/commands/greet", `{"name": "nobody"}`, http.StatusUnprocessableEntity},
+		{"/commands/unknown", `{}`, http.StatusBadRequest},
+		{"/commands/", `{}`, http.StatusNotFound},
+	}
+	for _, c := range cases {
+		if status, response := post(c.path, c.body); status != c.status || response.Failure == nil {
+			t.Fatalf("Expected %d for %s %s, got %d: %v", c.status, c.path, c.body, status, response)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/commands/greet", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected method not allowed, got %d", w.Code)
+	}
+}
+
+type testAuthenticator map[string]server.Principal
+
+func (a testAuthenticator) Authenticate(credentials server.Credentials) (server.Principal, error) {
+	if principal, ok := a[credentials.Token]; ok {
+		return principal, nil
+	}
+	return server.Principal{}, legionErrors.NewError(legionErrors.Failure, legionErrors.Unauthorized, "Invalid token.")
+}
+
+func TestRequestPrincipal(t *testing.T) {
+	s := server.New(testTimeService{}, &testPersistence{}, commandFactory, server.WithAuthenticator(testAuthenticator{
+		"alice-token": {UserId: "alice", TenantId: "acme"},
+		"bob-token":   {UserId: "bob"},
+	}))
+	if _, err := s.Connect("alice-conn", server.Credentials{Token: "alice-token"}); err != nil {
+		t.Fatal(err)
+	}
+	g := New(s)
+
+	post := func(header http.Header) (int, Response) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/commands/greet", strings.NewReader(`{"name": "Ann"}`))
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		g.ServeHTTP(w, r)
+		var response Response
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	status, response := post(http.Header{ConnectionHeader: {"alice-conn"}})
+	if status != http.StatusOK || response.Messages[0].Info["user_id"] != "" {
+		t.Fatalf("Expected anonymous principal, got %d: %v", status, response)
+	}
+	status, response = post(http.Header{"Authorization": {"Bearer alice-token"}, TenantHeader: {"acme"}})
+	if status != http.StatusOK || response.Messages[0].Info["user_id"] != "alice" {
+		t.Fatalf("Expected alice, got %d: %v", status, response)
+	}
+
+	cases := []struct {
+		header http.Header
+		status int
+	}{
+		{http.Header{TenantHeader: {"acme"}}, http.StatusUnauthorized},
+		{http.Header{"Authorization": {"Bearer bob-token"}, TenantHeader: {"acme"}}, http.StatusForbidden},
+		{http.Header{"Authorization": {"Bearer alice-token"}, TenantHeader: {"other"}}, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		if status, response := post(c.header); status != c.status || response.Failure == nil {
+			t.Fatalf("Expected %d for %v, got %d: %v", c.status, c.header, status, response)
+		}
+	}
+}
+
+func TestAcceptLanguage(t *testing.T) {
+	locale.Default.Add("fr", locale.Catalog{"Nobody to greet.": {locale.Other: "Personne à saluer."}})
+	g := New(server.New(testTimeService{}, &testPersistence{}, commandFactory))
+
+	post := func(path, acceptLanguage string) Response {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"name": "nobody"}`))
+		r.Header.Set("Accept-Language", acceptLanguage)
+		g.ServeHTTP(w, r)
+		var response Response
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response
+	}
+
+	if response := post("/commands/greet", "de-DE, fr-CH;q=0.8"); response.Failure.Description != "Personne à saluer." {
+		t.Fatalf("Expected translated failure, got %v", response.Failure)
+	}
+	if response := post("/commands/greet?locale=en", "fr"); response.Failure.Description != "Nobody to greet." {
+		t.Fatalf("Expected requested locale to win, got %v", response.Failure)
+	}
+}
+
+func TestStatusCodes(t *testing.T) {
+	cases := map[int]error{
+		http.StatusUnauthorized:        legionErrors.NewError(legionErrors.Failure, legionErrors.Unauthorized, ""),
+		http.StatusForbidden:           legionErrors.NewError(legionErrors.Failure, legionErrors.Unauthorized, "", es.Info{"user_id": es.EntityId("ann")}),
+		http.StatusInternalServerError: errors.New("panic"),
+	}
+	for status, err := range cases {
+		if StatusCode(err) != status {
+			t.Fatalf("Expected %d for %v, got %d", status, err, StatusCode(err))
+		}
+	}
+}
+
+func TestFailureCauses(t *testing.T) {
+	persistence := legionErrors.Wrap(errors.New("dial tcp 10.0.0.1:27017"), legionErrors.Failure, server.DatabaseError, "Failed to store messages.")
+	failure := asError(legionErrors.Wrap(persistence, legionErrors.Failure, "order_failed", "Order cannot be placed."))
+	cause, ok := failure.Cause.(legionErrors.Error)
+	if failure.Trace != nil || !ok || cause.Trace != nil || cause.Cause != nil {
+		t.Fatalf("Expected traces and internal causes to be stripped, got %v", failure)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	ready := errors.New("database is not connected")
+	g := New(server.New(testTimeService{}, &testPersistence{}, commandFactory), WithReadinessCheck(func() error { return ready }))
+
+	probe := func(path string) int {
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+	if probe("/health") != http.StatusOK || probe("/ready") != http.StatusServiceUnavailable {
+		t.Fatalf("Expected healthy service that is not ready.")
+	}
+	ready = nil
+	if probe("/ready") != http.StatusOK {
+		t.Fatalf("Expected service to be ready.")
+	}
+	g.Drain()
+	if probe("/ready") != http.StatusServiceUnavailable {
+		t.Fatalf("Expected draining service not to be ready.")
+	}
+}
@@ -0,0 +1,293 @@
+package gateway
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimal implementation of the WebSocket protocol (RFC 6455) on top of hijacked HTTP connections.
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	opContinuation byte = 0x0
+	opText         byte = 0x1
+	opBinary       byte = 0x2
+	opClose        byte = 0x8
+	opPing         byte = 0x9
+	opPong         byte = 0xA
+)
+
+type CloseCode uint16
+
+const (
+	CloseNormal          CloseCode = 1000
+	CloseGoingAway       CloseCode = 1001
+	CloseProtocolError   CloseCode = 1002
+	CloseUnsupportedData CloseCode = 1003
+	CloseNoStatus        CloseCode = 1005
+	CloseInvalidPayload  CloseCode = 1007
+	CloseMessageTooBig   CloseCode = 1009
+)
+
+// closeTimeout limits how long the closing side waits for the peer to confirm the close.
+const closeTimeout = time.Second
+
+// CloseError is returned by readMessage once the close handshake is complete.
+type CloseError struct {
+	Code   CloseCode
+	Reason string
+}
+
+func (e CloseError) Error() string {
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Reason)
+}
+
+type wsConn struct {
+	conn           net.Conn
+	reader         *bufio.Reader
+	client         bool
+	maxMessageSize int
+
+	writeLock sync.Mutex
+	closeSent bool
+}
+
+func headerContains(header http.Header, name, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(name)] {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func acceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// IsWebSocketUpgrade reports whether the request asks to switch to the WebSocket protocol.
+func IsWebSocketUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", "websocket")
+}
+
+// upgrade completes the opening handshake; on failure it responds to the request itself.
+func upgrade(w http.ResponseWriter, r *http.Request, maxMessageSize int) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || !IsWebSocketUpgrade(r) || key == "" {
+		http.Error(w, "Expected WebSocket handshake.", http.StatusBadRequest)
+		return nil, fmt.Errorf("not a websocket handshake")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version.", http.StatusUpgradeRequired)
+		return nil, fmt.Errorf("unsupported websocket version")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Connection cannot be upgraded.", http.StatusInternalServerError)
+		return nil, fmt.Errorf("response cannot be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	rw.WriteString("Upgrade: websocket\r\n")
+	rw.WriteString("Connection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, reader: rw.Reader, maxMessageSize: maxMessageSize}, nil
+}
+
+// readMessage returns the next data message; it answers pings and completes the close handshake.
+func (c *wsConn) readMessage() (opcode byte, payload []byte, err error) {
+	var message []byte
+	messageOpcode := byte(0)
+	for {
+		fin, op, data, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case opPing:
+			c.writeFrame(opPong, data)
+		case opPong:
+		case opClose:
+			closeErr := CloseError{Code: CloseNoStatus}
+			if len(data) >= 2 {
+				closeErr.Code = CloseCode(binary.BigEndian.Uint16(data))
+				closeErr.Reason = string(data[2:])
+			}
+			c.close(closeErr.Code, "")
+			return 0, nil, closeErr
+		case opText, opBinary:
+			if messageOpcode != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "Expected continuation frame.")
+			}
+			messageOpcode = op
+			message = data
+		case opContinuation:
+			if messageOpcode == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "Unexpected continuation frame.")
+			}
+			message = append(message, data...)
+		default:
+			return 0, nil, c.fail(CloseProtocolError, "Unknown opcode.")
+		}
+		if c.maxMessageSize > 0 && len(message) > c.maxMessageSize {
+			return 0, nil, c.fail(CloseMessageTooBig, "Message is too big.")
+		}
+		if fin && messageOpcode != 0 && op < opClose {
+			return messageOpcode, message, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.reader, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = head[0]&0x80 != 0
+	opcode = head[0] & 0x0F
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "Reserved bits are set.")
+	}
+	masked := head[1]&0x80 != 0
+	if masked == c.client {
+		return false, 0, nil, c.fail(CloseProtocolError, "Unexpected frame masking.")
+	}
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if opcode >= opClose && (length > 125 || !fin) {
+		return false, 0, nil, c.fail(CloseProtocolError, "Invalid control frame.")
+	}
+	if c.maxMessageSize > 0 && length > uint64(c.maxMessageSize) {
+		return false, 0, nil, c.fail(CloseMessageTooBig, "Message is too big.")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.reader, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+func (c *wsConn) writeMessage(opcode byte, payload []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if c.closeSent {
+		return CloseError{Code: CloseNormal, Reason: "Connection is closing."}
+	}
+	return c.writeFrameLocked(opcode, payload)
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return c.writeFrameLocked(opcode, payload)
+}
+
+// writeFrameLocked writes single unfragmented frame; clients mask their frames as required by the protocol.
+func (c *wsConn) writeFrameLocked(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|opcode)
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch length := len(payload); {
+	case length <= 125:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, maskBit|126, byte(length>>8), byte(length))
+	default:
+		frame = append(frame, maskBit|127)
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(length))
+		frame = append(frame, ext[:]...)
+	}
+	if c.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		for i, b := range payload {
+			frame = append(frame, b^mask[i%4])
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// close starts or confirms the close handshake; the peer has closeTimeout to respond
+// before reads fail and the connection is dropped.
+func (c *wsConn) close(code CloseCode, reason string) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if c.closeSent {
+		return nil
+	}
+	c.closeSent = true
+	c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+	var payload []byte
+	if code != CloseNoStatus {
+		payload = make([]byte, 2, 2+len(reason))
+		binary.BigEndian.PutUint16(payload, uint16(code))
+		payload = append(payload, reason...)
+	}
+	return c.writeFrameLocked(opClose, payload)
+}
+
+// fail closes the connection because of the protocol violation and returns the error for the reader.
+func (c *wsConn) fail(code CloseCode, reason string) error {
+	c.close(code, reason)
+	return CloseError{Code: code, Reason: reason}
+}
+
+func (c *wsConn) closeConn() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,159 @@
+package locale
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Message is a translation in plural forms; messages without plural forms have only Other.
+type Message map[Plural]string
+
+// UnmarshalJSON accepts a string or an object of plural forms, e.g. {"one": "{count} file", "other": "{count} files"}.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*m = Message{Other: text}
+		return nil
+	}
+	var forms map[Plural]string
+	if err := json.Unmarshal(data, &forms); err != nil {
+		return err
+	}
+	*m = forms
+	return nil
+}
+
+// Catalog maps message keys to their translations in a locale.
+type Catalog map[string]Message
+
+// ParseJSON reads a catalog from JSON object of keys to messages.
+func ParseJSON(r io.Reader) (Catalog, error) {
+	var catalog Catalog
+	if err := json.NewDecoder(r).Decode(&catalog); err != nil {
+		return nil, err
+	}
+	return catalog, nil
+}
+
+// ParsePO reads a catalog from gettext PO file of the locale. Plural forms msgstr[0], msgstr[1], ...
+// are mapped to plural categories of the locale, so Plural-Forms header is ignored; msgctxt is not supported.
+// Untranslated and fuzzy entries are skipped.
+func ParsePO(locale string, r io.Reader) (Catalog, error) {
+	catalog := Catalog{}
+	categories := pluralRuleOf(locale).categories
+
+	var (
+		fuzzy     bool
+		entry     = map[string]string{}
+		line      int
+		lastField string
+	)
+	flush := func() {
+		key := entry["msgid"]
+		if key != "" && !fuzzy {
+			message := Message{}
+			if text := entry["msgstr"]; text != "" {
+				message[Other] = text
+			}
+			for i, category := range categories {
+				if text := entry[fmt.Sprintf("msgstr[%d]", i)]; text != "" {
+					message[category] = text
+				}
+			}
+			if len(message) > 0 {
+				catalog[key] = message
+			}
+		}
+		entry = map[string]string{}
+		fuzzy = false
+		lastField = ""
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case text == "":
+			flush()
+		case strings.HasPrefix(text, "#"):
+			// comments precede entries
+			if lastField != "" {
+				flush()
+			}
+			if strings.HasPrefix(text, "#,") && strings.Contains(text, "fuzzy") {
+				fuzzy = true
+			}
+		case strings.HasPrefix(text, `"`):
+			value, err := strconv.Unquote(text)
+			if err != nil || lastField == "" {
+				return nil, fmt.Errorf("invalid PO string at line %d", line)
+			}
+			entry[lastField] += value
+		default:
+			fields := strings.SplitN(text, " ", 2)
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("invalid PO entry at line %d", line)
+			}
+			value, err := strconv.Unquote(strings.TrimSpace(fields[1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid PO string at line %d", line)
+			}
+			if fields[0] == "msgid" && lastField != "" && lastField != "msgid" {
+				flush()
+			}
+			lastField = fields[0]
+			entry[lastField] = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return catalog, nil
+}
+
+// LoadFile reads a catalog from .json or .po file named after its locale, e.g. "fr-CA.json".
+func LoadFile(path string) (locale string, catalog Catalog, err error) {
+	ext := filepath.Ext(path)
+	locale = Normalize(strings.TrimSuffix(filepath.Base(path), ext))
+	file, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer file.Close()
+	switch ext {
+	case ".json":
+		catalog, err = ParseJSON(file)
+	case ".po":
+		catalog, err = ParsePO(locale, file)
+	default:
+		err = fmt.Errorf("unsupported catalog format %q", ext)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return locale, catalog, nil
+}
+
+// catalogFiles lists .json and .po files of the directory.
+func catalogFiles(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, info := range infos {
+		if ext := filepath.Ext(info.Name()); !info.IsDir() && (ext == ".json" || ext == ".po") {
+			paths = append(paths, filepath.Join(dir, info.Name()))
+		}
+	}
+	return paths, nil
+}
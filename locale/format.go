@@ -0,0 +1,201 @@
+package locale
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrew-suprun/legion/es"
+)
+
+// Placeholder styles of Translate, e.g. "Total: {amount, currency}" or "Due {due, date}".
+const (
+	NumberStyle   = "number"
+	PercentStyle  = "percent"
+	CurrencyStyle = "currency"
+	DateStyle     = "date"
+	TimeStyle     = "time"
+	DateTimeStyle = "datetime"
+)
+
+const (
+	// CurrencyParam is the param with currency code for {amount, currency} placeholders;
+	// the code can also be given in the placeholder, e.g. {amount, currency, EUR}.
+	CurrencyParam = "currency"
+	// TimeZoneParam is the param with IANA time zone name or *time.Location of the user for date placeholders.
+	TimeZoneParam = "time_zone"
+)
+
+// FormatOf returns formatting conventions of the locale or of its closest parent; "en" conventions by default.
+func FormatOf(locale string) Format {
+	for _, parent := range parents(Normalize(locale)) {
+		if format, ok := formats[parent]; ok {
+			return format
+		}
+	}
+	return formats["en"]
+}
+
+// FormatNumber formats the number with at most 3 fraction digits.
+func FormatNumber(locale string, value float64) string {
+	return FormatOf(locale).number(value, 3, true)
+}
+
+// FormatDecimal formats the number with exactly the number of fraction digits.
+func FormatDecimal(locale string, value float64, fractionDigits int) string {
+	return FormatOf(locale).number(value, fractionDigits, false)
+}
+
+// FormatPercent formats the ratio as percents, e.g. 0.25 as "25%".
+func FormatPercent(locale string, ratio float64) string {
+	format := FormatOf(locale)
+	number := format.number(ratio*100, 0, false)
+	return sign(number) + strings.Replace(format.Percent, "#", unsigned(number), 1)
+}
+
+// FormatCurrency formats the amount with minor units and symbol of the currency, e.g. "$1,234.50" or "1.234,50 €".
+func FormatCurrency(locale string, amount float64, currency string) string {
+	format := FormatOf(locale)
+	currency = strings.ToUpper(currency)
+	digits, ok := currencyDigits[currency]
+	if !ok {
+		digits = 2
+	}
+	symbol, ok := format.Symbols[currency]
+	if !ok {
+		if symbol, ok = currencySymbols[currency]; !ok {
+			symbol = currency
+		}
+	}
+	number := format.number(amount, digits, false)
+	pattern := format.Currency
+	if symbol == currency && strings.Contains(pattern, "¤#") {
+		// codes are separated from numbers
+		pattern = strings.Replace(pattern, "¤#", "¤"+nbsp+"#", 1)
+	}
+	return sign(number) + strings.NewReplacer("¤", symbol, "#", unsigned(number)).Replace(pattern)
+}
+
+func FormatDate(locale string, t time.Time) string {
+	return t.Format(FormatOf(locale).Date)
+}
+
+func FormatTime(locale string, t time.Time) string {
+	return t.Format(FormatOf(locale).Time)
+}
+
+func FormatDateTime(locale string, t time.Time) string {
+	return t.Format(FormatOf(locale).DateTime)
+}
+
+// number formats the value with grouped integer part; trailing zeros of fraction are trimmed if trim is true.
+func (f Format) number(value float64, fractionDigits int, trim bool) string {
+	text := strconv.FormatFloat(value, 'f', fractionDigits, 64)
+	negative := strings.HasPrefix(text, "-")
+	text = strings.TrimPrefix(text, "-")
+	integer, fraction := text, ""
+	if i := strings.IndexByte(text, '.'); i >= 0 {
+		integer, fraction = text[:i], text[i+1:]
+	}
+	if trim {
+		fraction = strings.TrimRight(fraction, "0")
+	}
+
+	minGrouping := f.MinGrouping
+	if minGrouping == 0 {
+		minGrouping = 4
+	}
+	if len(integer) >= minGrouping {
+		var grouped strings.Builder
+		for i, digit := range integer {
+			if i > 0 && (len(integer)-i)%3 == 0 {
+				grouped.WriteString(f.Group)
+			}
+			grouped.WriteRune(digit)
+		}
+		integer = grouped.String()
+	}
+
+	result := integer
+	if fraction != "" {
+		result += f.Decimal + fraction
+	}
+	if negative && strings.Trim(text, "0.") != "" {
+		result = "-" + result
+	}
+	return result
+}
+
+func sign(number string) string {
+	if strings.HasPrefix(number, "-") {
+		return "-"
+	}
+	return ""
+}
+
+func unsigned(number string) string {
+	return strings.TrimPrefix(number, "-")
+}
+
+// formatValue formats the value of a placeholder in the style; values that cannot be formatted in the style are returned as false.
+func formatValue(locale string, value interface{}, style, arg string, params es.Info) (string, bool) {
+	switch style {
+	case NumberStyle, PercentStyle, CurrencyStyle:
+		number, ok := toFloat(value)
+		if !ok {
+			return "", false
+		}
+		switch style {
+		case NumberStyle:
+			if arg != "" {
+				if digits, err := strconv.Atoi(arg); err == nil {
+					return FormatDecimal(locale, number, digits), true
+				}
+			}
+			return FormatNumber(locale, number), true
+		case PercentStyle:
+			return FormatPercent(locale, number), true
+		}
+		currency := arg
+		if currency == "" {
+			currency, _ = params[CurrencyParam].(string)
+		}
+		if currency == "" {
+			return "", false
+		}
+		return FormatCurrency(locale, number, currency), true
+	case DateStyle, TimeStyle, DateTimeStyle:
+		t, ok := toTime(value)
+		if !ok {
+			return "", false
+		}
+		switch zone := params[TimeZoneParam].(type) {
+		case *time.Location:
+			t = t.In(zone)
+		case string:
+			if location, err := time.LoadLocation(zone); err == nil {
+				t = t.In(location)
+			}
+		}
+		switch style {
+		case DateStyle:
+			return FormatDate(locale, t), true
+		case TimeStyle:
+			return FormatTime(locale, t), true
+		}
+		return FormatDateTime(locale, t), true
+	}
+	return "", false
+}
+
+// toTime accepts time.Time and RFC 3339 strings of JSON info.
+func toTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		t, err := time.Parse(time.RFC3339, v)
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,94 @@
+package locale
+
+import (
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/andrew-suprun/legion/es"
+)
+
+// readable replaces non-breaking spaces to keep expectations legible.
+func readable(text string) string {
+	return strings.NewReplacer(nbsp, "_", narrowNbsp, "_").Replace(text)
+}
+
+func TestFormatNumbers(t *testing.T) {
+	cases := []struct {
+		actual, expected string
+	}{
+		{FormatNumber("en-US", 1234567.891), "1,234,567.891"},
+		{FormatNumber("en", 1234.5), "1,234.5"},
+		{FormatNumber("de", -1234.5), "-1.234,5"},
+		{FormatNumber("fr", 1234567), "1_234_567"},
+		{FormatNumber("es", 1234), "1234"},
+		{FormatNumber("es", 12345), "12.345"},
+		{FormatNumber("en", -0.0001), "0"},
+		{FormatDecimal("ru", 1234.5, 2), "1_234,50"},
+		{FormatPercent("en", 0.256), "26%"},
+		{FormatPercent("de", 0.25), "25_%"},
+		{FormatPercent("tr", -0.5), "-%50"},
+		{FormatCurrency("en", 1234.5, "USD"), "$1,234.50"},
+		{FormatCurrency("en", -3, "EUR"), "-€3.00"},
+		{FormatCurrency("de-AT", 1234.5, "EUR"), "1.234,50_€"},
+		{FormatCurrency("fr-CA", 10, "USD"), "10,00_$_US"},
+		{FormatCurrency("ja", 1234.5, "JPY"), "￥1,234"},
+		{FormatCurrency("pt-BR", 99.9, "BRL"), "R$_99,90"},
+		{FormatCurrency("en", 1.5, "CHF"), "CHF_1.50"},
+		{FormatCurrency("en", 1.5, "KWD"), "KWD_1.500"},
+	}
+	for _, c := range cases {
+		if readable(c.actual) != c.expected {
+			t.Errorf("Expected %q, got %q", c.expected, readable(c.actual))
+		}
+	}
+}
+
+func TestFormatDates(t *testing.T) {
+	at := time.Date(2020, 3, 7, 14, 5, 0, 0, time.UTC)
+	cases := []struct {
+		actual, expected string
+	}{
+		{FormatDate("en", at), "3/7/2020"},
+		{FormatDate("en-GB", at), "07/03/2020"},
+		{FormatDate("de", at), "07.03.2020"},
+		{FormatDate("sv", at), "2020-03-07"},
+		{FormatDate("ja", at), "2020/03/07"},
+		{FormatTime("en", at), "2:05 PM"},
+		{FormatTime("fr-CA", at), "14 h 05"},
+		{FormatDateTime("ko", at), "2020. 3. 7. 14:05"},
+	}
+	for _, c := range cases {
+		if c.actual != c.expected {
+			t.Errorf("Expected %q, got %q", c.expected, c.actual)
+		}
+	}
+}
+
+func TestFormattedPlaceholders(t *testing.T) {
+	translator := NewTranslator()
+	translator.Add("de", Catalog{
+		"Paid {amount, currency} on {paid_at, datetime}.": {Other: "Am {paid_at, datetime} wurden {amount, currency} bezahlt."},
+	})
+	params := es.Info{
+		"amount":      1234.5,
+		"currency":    "EUR",
+		"paid_at":     "2020-03-07T14:05:00Z",
+		TimeZoneParam: "Europe/Berlin",
+	}
+	cases := []struct {
+		locale, key, expected string
+	}{
+		{"de", "Paid {amount, currency} on {paid_at, datetime}.", "Am 07.03.2020, 15:05 wurden 1.234,50_€ bezahlt."},
+		{"en", "Paid {amount, currency} on {paid_at, datetime}.", "Paid €1,234.50 on 3/7/2020, 3:05 PM."},
+		{"en", "Paid {amount, currency, USD}, {amount, number, 2} or {amount, number}.", "Paid $1,234.50, 1,234.50 or 1,234.5."},
+		{"en", "{amount, percent} of {paid_at, date}", "123,450% of 3/7/2020"},
+		{"en", "{currency, number} {amount, unknown}", "{currency, number} {amount, unknown}"},
+	}
+	for _, c := range cases {
+		if actual := readable(translator.Translate(c.locale, c.key, params)); actual != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.locale, c.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package locale
+
+const (
+	nbsp       = "\u00a0"
+	narrowNbsp = "\u202f"
+)
+
+// Format holds conventions of a locale for formatting numbers and dates.
+//
+// Percent and Currency are patterns where "#" stands for the formatted number and "¤" for currency symbol;
+// Date, Time and DateTime are layouts of the time package.
+type Format struct {
+	Decimal     string
+	Group       string
+	MinGrouping int // integer parts with fewer digits are not grouped, 4 if zero
+	Percent     string
+	Currency    string
+	Date        string
+	Time        string
+	DateTime    string
+	Symbols     map[string]string // currency symbols overriding common ones
+}
+
+// currencySymbols are used by locales that have no symbol of their own for the currency.
+var currencySymbols = map[string]string{
+	"AUD": "A$",
+	"BRL": "R$",
+	"CAD": "CA$",
+	"CNY": "CN¥",
+	"EUR": "€",
+	"GBP": "£",
+	"ILS": "₪",
+	"INR": "₹",
+	"JPY": "JP¥",
+	"KRW": "₩",
+	"MXN": "MX$",
+	"USD": "US$",
+	"VND": "₫",
+}
+
+// currencyDigits are numbers of minor units of currencies that differ from 2.
+var currencyDigits = map[string]int{
+	"BHD": 3, "BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "IQD": 3, "ISK": 0, "JOD": 3, "JPY": 0, "KMF": 0,
+	"KRW": 0, "KWD": 3, "LYD": 3, "OMR": 3, "PYG": 0, "RWF": 0, "TND": 3, "UGX": 0, "UYI": 0, "VND": 0,
+	"VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+}
+
+// formats are built-in conventions by locale; locales without their own entry use formats of their parents.
+var formats = map[string]Format{
+	"en": {
+		Decimal: ".", Group: ",", Percent: "#%", Currency: "¤#",
+		Date: "1/2/2006", Time: "3:04 PM", DateTime: "1/2/2006, 3:04 PM",
+		Symbols: map[string]string{"USD": "$", "JPY": "¥"},
+	},
+	"en-GB": {
+		Decimal: ".", Group: ",", Percent: "#%", Currency: "¤#",
+		Date: "02/01/2006", Time: "15:04", DateTime: "02/01/2006, 15:04",
+	},
+	"de": {
+		Decimal: ",", Group: ".", Percent: "#" + nbsp + "%", Currency: "#" + nbsp + "¤",
+		Date: "02.01.2006", Time: "15:04", DateTime: "02.01.2006, 15:04",
+		Symbols: map[string]string{"USD": "$", "JPY": "¥"},
+	},
+	"fr": {
+		Decimal: ",", Group: narrowNbsp, Percent: "#" + narrowNbsp + "%", Currency: "#" + nbsp + "¤",
+		Date: "02/01/2006", Time: "15:04", DateTime: "02/01/2006 15:04",
+		Symbols: map[string]string{"USD": "$US", "JPY": "JPY", "CAD": "$CA"},
+	},
+	"fr-CA": {
+		Decimal: ",", Group: nbsp, Percent: "#" + nbsp + "%", Currency: "#" + nbsp + "¤",
+		Date: "2006-01-02", Time: "15 h 04", DateTime: "2006-01-02 15 h 04",
+		Symbols: map[string]string{"USD": "$" + nbsp + "US", "CAD": "$"},
+	},
+	"es": {
+		Decimal: ",", Group: ".", MinGrouping: 5, Percent: "#" + nbsp + "%", Currency: "#" + nbsp + "¤",
+		Date: "2/1/2006", Time: "15:04", DateTime: "2/1/2006, 15:04",
+		Symbols: map[string]string{"USD": "US$", "JPY": "JPY"},
+	},
+	"it": {
+		Decimal: ",", Group: ".", Percent: "#%", Currency: "#" + nbsp + "¤",
+		Date: "02/01/2006", Time: "15:04", DateTime: "02/01/2006, 15:04",
+		Symbols: map[string]string{"USD": "USD", "JPY": "JPY"},
+	},
+	"pt": {
+		Decimal: ",", Group: nbsp, MinGrouping: 5, Percent: "#%", Currency: "#" + nbsp + "¤",
+		Date: "02/01/2006", Time: "15:04", DateTime: "02/01/2006, 15:04",
+		Symbols: map[string]string{"USD": "US$", "JPY": "JP¥"},
+	},
+	"pt-BR": {
+		Decimal: ",", Group: ".", Percent: "#%", Currency: "¤" + nbsp + "#",
+		Date: "02/01/2006", Time: "15:04", DateTime: "02/01/2006, 15:04",
+		Symbols: map[string]string{"USD": "US$", "JPY": "JP¥"},
+	},
+	"nl": {
+		Decimal: ",", Group: ".", Percent: "#%", Currency: "¤" + nbsp + "#",
+		Date: "02-01-2006", Time: "15:04", DateTime: "02-01-2006 15:04",
+		Symbols: map[string]string{"USD": "US$", "JPY": "JP¥"},
+	},
+	"sv": {
+		Decimal: ",", Group: nbsp, Percent: "#" + nbsp + "%", Currency: "#" + nbsp + "¤",
+		Date: "2006-01-02", Time: "15:04", DateTime: "2006-01-02 15:04",
+		Symbols: map[string]string{"SEK": "kr", "USD": "US$"},
+	},
+	"pl": {
+		Decimal: ",", Group: nbsp, MinGrouping: 5, Percent: "#%", Currency: "#" + nbsp + "¤",
+		Date: "02.01.2006", Time: "15:04", DateTime: "02.01.2006, 15:04",
+		Symbols: map[string]string{"PLN": "zł", "USD": "USD"},
+	},
+	"ru": {
+		Decimal: ",", Group: nbsp, Percent: "#" + nbsp + "%", Currency: "#" + nbsp + "¤",
+		Date: "02.01.2006", Time: "15:04", DateTime: "02.01.2006, 15:04",
+		Symbols: map[string]string{"RUB": "₽", "USD": "$", "UAH": "₴"},
+	},
+	"uk": {
+		Decimal: ",", Group: nbsp, Percent: "#%", Currency: "#" + nbsp + "¤",
+		Date: "02.01.2006", Time: "15:04", DateTime: "02.01.2006, 15:04",
+		Symbols: map[string]string{"UAH": "₴", "USD": "USD"},
+	},
+	"tr": {
+		Decimal: ",", Group: ".", Percent: "%#", Currency: "¤#",
+		Date: "02.01.2006", Time: "15:04", DateTime: "02.01.2006 15:04",
+		Symbols: map[string]string{"TRY": "₺", "USD": "$"},
+	},
+	"ja": {
+		Decimal: ".", Group: ",", Percent: "#%", Currency: "¤#",
+		Date: "2006/01/02", Time: "15:04", DateTime: "2006/01/02 15:04",
+		Symbols: map[string]string{"JPY": "￥", "USD": "$", "CNY": "元"},
+	},
+	"zh": {
+		Decimal: ".", Group: ",", Percent: "#%", Currency: "¤#",
+		Date: "2006/1/2", Time: "15:04", DateTime: "2006/1/2 15:04",
+		Symbols: map[string]string{"CNY": "¥", "USD": "US$", "JPY": "JP¥"},
+	},
+	"ko": {
+		Decimal: ".", Group: ",", Percent: "#%", Currency: "¤#",
+		Date: "2006. 1. 2.", Time: "15:04", DateTime: "2006. 1. 2. 15:04",
+		Symbols: map[string]string{"USD": "US$", "JPY": "JP¥"},
+	},
+}
@@ -2,10 +2,272 @@ package locale
 
 import (
 	"fmt"
+	"strings"
+	"sync"
 
 	"github.com/andrew-suprun/legion/es"
 )
 
+// PluralParam is the param that selects plural form of messages.
+const PluralParam = "count"
+
+// Translator translates message keys with catalogs of locales. Keys are texts in the fallback locale
+// with {placeholders} for params, e.g. "Account {account_id} is closed."; placeholders with styles,
+// e.g. "Balance is {balance, currency}.", are formatted for the locale. Translations are looked up
+// in the locale and its parents (fr-CA, fr) and then in the fallback locale; keys without translation
+// are used as is.
+type Translator struct {
+	lock      sync.RWMutex
+	catalogs  map[string]Catalog
+	fallback  string
+	onMissing func(locale, key string)
+}
+
+type Option func(t *Translator)
+
+// WithFallback sets locale of message keys, "en" by default.
+func WithFallback(locale string) Option {
+	return func(t *Translator) {
+		t.fallback = Normalize(locale)
+	}
+}
+
+// WithMissingKeyReporter reports keys that have no translation in the requested language.
+// Keys requested in the language of the fallback locale are never reported.
+func WithMissingKeyReporter(report func(locale, key string)) Option {
+	return func(t *Translator) {
+		t.onMissing = report
+	}
+}
+
+func NewTranslator(options ...Option) *Translator {
+	t := &Translator{
+		catalogs: map[string]Catalog{},
+		fallback: "en",
+	}
+	for _, option := range options {
+		option(t)
+	}
+	return t
+}
+
+// Add merges the catalog into translations of the locale.
+func (t *Translator) Add(locale string, catalog Catalog) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	locale = Normalize(locale)
+	merged, ok := t.catalogs[locale]
+	if !ok {
+		merged = Catalog{}
+		t.catalogs[locale] = merged
+	}
+	for key, message := range catalog {
+		merged[key] = message
+	}
+}
+
+// LoadFile adds a catalog file named after its locale, e.g. "fr-CA.json" or "fr.po".
+func (t *Translator) LoadFile(path string) error {
+	locale, catalog, err := LoadFile(path)
+	if err != nil {
+		return err
+	}
+	t.Add(locale, catalog)
+	return nil
+}
+
+// LoadDir adds all .json and .po catalog files of the directory.
+func (t *Translator) LoadDir(dir string) error {
+	paths, err := catalogFiles(dir)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := t.LoadFile(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Translate returns translation of the key in the locale with placeholders replaced by params.
+func (t *Translator) Translate(locale, key string, params ...es.Info) string {
+	locale = Normalize(locale)
+	if locale == "" {
+		locale = t.fallback
+	}
+	merged := es.Info{}
+	for _, p := range params {
+		for k, v := range p {
+			merged[k] = v
+		}
+	}
+
+	requested := parents(locale)
+	chain := requested
+	for _, parent := range parents(t.fallback) {
+		if !contains(chain, parent) {
+			chain = append(chain, parent)
+		}
+	}
+
+	t.lock.RLock()
+	message, found := Message(nil), ""
+	for _, candidate := range chain {
+		if m, ok := t.catalogs[candidate][key]; ok {
+			message, found = m, candidate
+			break
+		}
+	}
+	t.lock.RUnlock()
+
+	if !contains(requested, found) && language(locale) != language(t.fallback) && t.onMissing != nil {
+		t.onMissing(locale, key)
+	}
+	if message == nil {
+		return substitute(locale, key, merged)
+	}
+	return substitute(locale, message.text(found, merged), merged)
+}
+
+// text selects plural form by PluralParam; Other is used when the form is missing.
+func (m Message) text(locale string, params es.Info) string {
+	rule := pluralRuleOf(locale)
+	if count, ok := toFloat(params[PluralParam]); ok {
+		if text, ok := m[rule.category(count)]; ok {
+			return text
+		}
+	}
+	if text, ok := m[Other]; ok {
+		return text
+	}
+	if text, ok := m[rule.fallback]; ok {
+		return text
+	}
+	for _, category := range []Plural{One, Few, Many, Two, Zero} {
+		if text, ok := m[category]; ok {
+			return text
+		}
+	}
+	return ""
+}
+
+// substitute replaces {name} and {name, style[, arg]} placeholders with params formatted for the locale;
+// placeholders without params are left as is.
+func substitute(locale, text string, params es.Info) string {
+	if !strings.Contains(text, "{") {
+		return text
+	}
+	var result strings.Builder
+	for {
+		start := strings.IndexByte(text, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(text[start:], '}')
+		if end < 0 {
+			break
+		}
+		end += start
+		result.WriteString(text[:start])
+		if value, ok := placeholder(locale, text[start+1:end], params); ok {
+			result.WriteString(value)
+		} else {
+			result.WriteString(text[start : end+1])
+		}
+		text = text[end+1:]
+	}
+	result.WriteString(text)
+	return result.String()
+}
+
+func placeholder(locale, spec string, params es.Info) (string, bool) {
+	parts := strings.Split(spec, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	value, ok := params[parts[0]]
+	if !ok {
+		return "", false
+	}
+	if len(parts) == 1 {
+		return fmt.Sprint(value), true
+	}
+	arg := ""
+	if len(parts) > 2 {
+		arg = parts[2]
+	}
+	return formatValue(locale, value, parts[1], arg, params)
+}
+
+// Normalize converts locale tags to the form used for lookups, e.g. "fr_ca" to "fr-CA".
+func Normalize(locale string) string {
+	parts := strings.FieldsFunc(locale, func(r rune) bool { return r == '-' || r == '_' })
+	for i, part := range parts {
+		switch {
+		case i == 0:
+			parts[i] = strings.ToLower(part)
+		case len(part) == 2:
+			parts[i] = strings.ToUpper(part)
+		case len(part) == 4:
+			parts[i] = strings.ToUpper(part[:1]) + strings.ToLower(part[1:])
+		default:
+			parts[i] = strings.ToLower(part)
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// parents lists the locale and its parents from the most specific one, e.g. zh-Hant-TW, zh-Hant, zh.
+func parents(locale string) []string {
+	var result []string
+	for locale != "" {
+		result = append(result, locale)
+		i := strings.LastIndexByte(locale, '-')
+		if i < 0 {
+			break
+		}
+		locale = locale[:i]
+	}
+	return result
+}
+
+func language(locale string) string {
+	if i := strings.IndexAny(locale, "-_"); i >= 0 {
+		return strings.ToLower(locale[:i])
+	}
+	return strings.ToLower(locale)
+}
+
+func contains(locales []string, locale string) bool {
+	for _, l := range locales {
+		if l == locale {
+			return true
+		}
+	}
+	return false
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// Default is the translator used by Translate.
+var Default = NewTranslator()
+
+// Translate translates the key with the Default translator.
 func Translate(locale, text string, params ...es.Info) string {
-	return fmt.Sprintf("translation for %s: %q", locale, text)
+	return Default.Translate(locale, text, params...)
 }
@@ -0,0 +1,147 @@
+package locale
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andrew-suprun/legion/es"
+)
+
+const frJSON = `{
+	"Hello, {name}!": "Bonjour, {name} !",
+	"Colour": "Couleur",
+	"{count} files": {"one": "{count} fichier", "other": "{count} fichiers"}
+}`
+
+const frCAJSON = `{
+	"Colour": "Couleur (CA)"
+}`
+
+const ruPO = `# Russian translation
+msgid ""
+msgstr ""
+"Plural-Forms: nplurals=3; plural=(n%10==1 && n%100!=11 ? 0 : n%10>=2 && n%10<=4 && (n%100<10 || n%100>=20) ? 1 : 2);\n"
+
+msgid "Hello, {name}!"
+msgstr "Привет, {name}!"
+
+#, fuzzy
+msgid "Colour"
+msgstr "Цвет?"
+
+msgid "{count} files"
+msgid_plural "{count} files"
+msgstr[0] "{count} файл"
+msgstr[1] "{count} "
+"файла"
+msgstr[2] "{count} файлов"
+`
+
+func newTestTranslator(t *testing.T, options ...Option) *Translator {
+	dir, err := ioutil.TempDir("", "locale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{"fr.json": frJSON, "fr_CA.json": frCAJSON, "ru.po": ruPO} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	translator := NewTranslator(options...)
+	if err := translator.LoadDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	return translator
+}
+
+func TestTranslate(t *testing.T) {
+	translator := newTestTranslator(t)
+	cases := []struct {
+		locale, key string
+		params      es.Info
+		expected    string
+	}{
+		{"fr", "Hello, {name}!", es.Info{"name": "Ann"}, "Bonjour, Ann !"},
+		{"fr-CA", "Hello, {name}!", es.Info{"name": "Ann"}, "Bonjour, Ann !"},
+		{"fr-ca", "Colour", nil, "Couleur (CA)"},
+		{"fr-FR", "Colour", nil, "Couleur"},
+		{"fr", "{count} files", es.Info{"count": 0}, "0 fichier"},
+		{"fr", "{count} files", es.Info{"count": 2}, "2 fichiers"},
+		{"ru", "Hello, {name}!", es.Info{"name": "Аня"}, "Привет, Аня!"},
+		{"ru", "{count} files", es.Info{"count": 21}, "21 файл"},
+		{"ru", "{count} files", es.Info{"count": 3}, "3 файла"},
+		{"ru", "{count} files", es.Info{"count": 11}, "11 файлов"},
+		{"ru", "{count} files", es.Info{"count": 1.5}, "1.5 файла"},
+		{"fr", "{count} files", es.Info{"count": 2.5}, "2.5 fichiers"},
+		{"ru", "Colour", nil, "Colour"},
+		{"de", "Hello, {name}!", es.Info{"name": "Ann"}, "Hello, Ann!"},
+		{"", "Hello, {name}!", es.Info{"name": "Ann"}, "Hello, Ann!"},
+		{"en", "Hello, {missing}!", nil, "Hello, {missing}!"},
+	}
+	for _, c := range cases {
+		if actual := translator.Translate(c.locale, c.key, c.params); actual != c.expected {
+			t.Errorf("%s %q: expected %q, got %q", c.locale, c.key, c.expected, actual)
+		}
+	}
+}
+
+func TestFallbackLocaleCatalog(t *testing.T) {
+	translator := newTestTranslator(t)
+	translator.Add("en", Catalog{"Colour": {Other: "Color"}})
+	if actual := translator.Translate("de", "Colour"); actual != "Color" {
+		t.Fatalf("Expected fallback translation, got %q", actual)
+	}
+	if actual := translator.Translate("fr", "Colour"); actual != "Couleur" {
+		t.Fatalf("Expected French translation, got %q", actual)
+	}
+}
+
+func TestMissingKeys(t *testing.T) {
+	var missing []string
+	translator := newTestTranslator(t, WithMissingKeyReporter(func(locale, key string) {
+		missing = append(missing, locale+": "+key)
+	}))
+	translator.Translate("fr-CA", "Colour")
+	translator.Translate("fr-CA", "Hello, {name}!")
+	translator.Translate("ru", "Colour")
+	translator.Translate("de-AT", "Colour")
+	translator.Translate("en-GB", "Colour")
+	if strings.Join(missing, "; ") != "ru: Colour; de-AT: Colour" {
+		t.Fatalf("Unexpected missing keys %v", missing)
+	}
+}
+
+func TestInvalidCatalogs(t *testing.T) {
+	if _, err := ParsePO("fr", strings.NewReader("msgid \"a\"\nmsgstr unquoted\n")); err == nil {
+		t.Fatal("Expected error for invalid PO")
+	}
+	if _, err := ParseJSON(strings.NewReader(`{"a": 1}`)); err == nil {
+		t.Fatal("Expected error for invalid JSON")
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	if preferred := ParseAcceptLanguage("de;q=0.5, fr_ca, *;q=0.9, ru;q=0"); strings.Join(preferred, ",") != "fr-CA,de" {
+		t.Fatalf("Unexpected preferred locales %v", preferred)
+	}
+	translator := newTestTranslator(t)
+	cases := []struct {
+		preferred []string
+		expected  string
+	}{
+		{[]string{"fr-CA", "de"}, "fr-CA"},
+		{[]string{"de", "fr-BE"}, "fr-BE"},
+		{[]string{"de", "en-GB"}, "en-GB"},
+		{[]string{"de"}, "en"},
+		{nil, "en"},
+	}
+	for _, c := range cases {
+		if actual := translator.Negotiate(c.preferred...); actual != c.expected {
+			t.Errorf("%v: expected %q, got %q", c.preferred, c.expected, actual)
+		}
+	}
+}
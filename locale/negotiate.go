@@ -0,0 +1,64 @@
+package locale
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParseAcceptLanguage returns locales of Accept-Language header from the most preferred one;
+// the wildcard and locales with zero quality are omitted.
+func ParseAcceptLanguage(header string) []string {
+	type weighted struct {
+		locale  string
+		quality float64
+	}
+	var ranges []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.TrimSpace(fields[0])
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if tag != "" && tag != "*" && quality > 0 {
+			ranges = append(ranges, weighted{Normalize(tag), quality})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].quality > ranges[j].quality })
+	locales := make([]string, len(ranges))
+	for i, r := range ranges {
+		locales[i] = r.locale
+	}
+	return locales
+}
+
+// Negotiate returns the first preferred locale which language has catalogs or is the language
+// of the fallback locale, and the fallback locale if there is no such locale.
+func (t *Translator) Negotiate(preferred ...string) string {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	for _, candidate := range preferred {
+		candidate = Normalize(candidate)
+		lang := language(candidate)
+		if lang == language(t.fallback) {
+			return candidate
+		}
+		for catalogLocale := range t.catalogs {
+			if language(catalogLocale) == lang {
+				return candidate
+			}
+		}
+	}
+	return t.fallback
+}
+
+// Negotiate negotiates the locale with the Default translator.
+func Negotiate(preferred ...string) string {
+	return Default.Negotiate(preferred...)
+}
@@ -0,0 +1,110 @@
+package locale
+
+import "math"
+
+// Plural is a CLDR plural category.
+type Plural string
+
+const (
+	Zero  Plural = "zero"
+	One   Plural = "one"
+	Two   Plural = "two"
+	Few   Plural = "few"
+	Many  Plural = "many"
+	Other Plural = "other"
+)
+
+// pluralRule selects plural category of a number and lists categories of the language
+// in order of gettext plural forms. Messages without the category of a number, e.g. fractions
+// in catalogs with gettext forms only, use Other and then the fallback category.
+type pluralRule struct {
+	categories []Plural
+	category   func(n float64) Plural
+	fallback   Plural
+}
+
+var (
+	oneOther = pluralRule{[]Plural{One, Other}, func(n float64) Plural {
+		if n == 1 {
+			return One
+		}
+		return Other
+	}, Other}
+	zeroOneOther = pluralRule{[]Plural{One, Other}, func(n float64) Plural {
+		if n >= 0 && n < 2 {
+			return One
+		}
+		return Other
+	}, Other}
+	otherOnly = pluralRule{[]Plural{Other}, func(n float64) Plural {
+		return Other
+	}, Other}
+	// fractions are Other in CLDR; gettext catalogs have no such form and use Few,
+	// the genitive singular, e.g. "1,5 файла"
+	slavic = pluralRule{[]Plural{One, Few, Many, Other}, func(n float64) Plural {
+		if n != math.Trunc(n) {
+			return Other
+		}
+		mod10, mod100 := math.Mod(n, 10), math.Mod(n, 100)
+		switch {
+		case mod10 == 1 && mod100 != 11:
+			return One
+		case mod10 >= 2 && mod10 <= 4 && (mod100 < 12 || mod100 > 14):
+			return Few
+		}
+		return Many
+	}, Few}
+	polish = pluralRule{[]Plural{One, Few, Many, Other}, func(n float64) Plural {
+		if n != math.Trunc(n) {
+			return Other
+		}
+		mod10, mod100 := math.Mod(n, 10), math.Mod(n, 100)
+		switch {
+		case n == 1:
+			return One
+		case mod10 >= 2 && mod10 <= 4 && (mod100 < 12 || mod100 > 14):
+			return Few
+		}
+		return Many
+	}, Few}
+	arabic = pluralRule{[]Plural{Zero, One, Two, Few, Many, Other}, func(n float64) Plural {
+		mod100 := math.Mod(n, 100)
+		switch {
+		case n == 0:
+			return Zero
+		case n == 1:
+			return One
+		case n == 2:
+			return Two
+		case n == math.Trunc(n) && mod100 >= 3 && mod100 <= 10:
+			return Few
+		case n == math.Trunc(n) && mod100 >= 11:
+			return Many
+		}
+		return Other
+	}, Other}
+)
+
+// pluralRules are rules by language; languages not listed use one/other rule of English.
+var pluralRules = map[string]pluralRule{
+	"ar": arabic,
+	"fr": zeroOneOther,
+	"pt": zeroOneOther,
+	"ru": slavic,
+	"uk": slavic,
+	"be": slavic,
+	"pl": polish,
+	"ja": otherOnly,
+	"ko": otherOnly,
+	"zh": otherOnly,
+	"th": otherOnly,
+	"vi": otherOnly,
+	"id": otherOnly,
+}
+
+func pluralRuleOf(locale string) pluralRule {
+	if rule, ok := pluralRules[language(locale)]; ok {
+		return rule
+	}
+	return oneOther
+}
@@ -0,0 +1,64 @@
+package in_memory
+
+import (
+	"time"
+
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/server"
+)
+
+type idempotencyKey struct {
+	tenantId es.TenantId
+	key      string
+}
+
+func (p *persistence) ReserveIdempotencyKey(reservation server.IdempotentResult, expiredBefore, leaseExpiredBefore time.Time) (bool, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	key := idempotencyKey{tenantId: reservation.TenantId, key: reservation.Key}
+	if result, ok := p.results[key]; ok {
+		if result.Pending && !result.CompletedAt.Before(leaseExpiredBefore) {
+			return false, nil
+		}
+		if !result.Pending && !result.CompletedAt.Before(expiredBefore) {
+			return false, nil
+		}
+	}
+	p.results[key] = reservation
+	return true, nil
+}
+
+func (p *persistence) PersistIdempotentResult(result server.IdempotentResult) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.results[idempotencyKey{tenantId: result.TenantId, key: result.Key}] = result
+	return nil
+}
+
+func (p *persistence) FetchIdempotentResult(tenantId es.TenantId, key string) (*server.IdempotentResult, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	result, ok := p.results[idempotencyKey{tenantId: tenantId, key: key}]
+	if !ok {
+		return nil, nil
+	}
+	return &result, nil
+}
+
+func (p *persistence) DeleteIdempotentResult(tenantId es.TenantId, key string) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.results, idempotencyKey{tenantId: tenantId, key: key})
+	return nil
+}
+
+func (p *persistence) DeleteIdempotentResults(completedBefore time.Time) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for key, result := range p.results {
+		if result.CompletedAt.Before(completedBefore) {
+			delete(p.results, key)
+		}
+	}
+	return nil
+}
@@ -1,25 +1,58 @@
 package in_memory
 
 import (
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/andrew-suprun/legion/aggregates"
 	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/queue/durable"
 	"github.com/andrew-suprun/legion/server"
 
 	"github.com/reillywatson/goloose"
 )
 
+// persistence is a view of the store limited to a single tenant.
 type persistence struct {
+	*store
+	tenantId es.TenantId
+}
+
+type store struct {
+	lock          sync.Mutex
 	entityFactory server.EntityFactory
-	events        map[es.EntityType]map[es.EntityId]es.Events
+	upcasters     *aggregates.Upcasters
+	events        map[es.TenantId]map[es.EntityType]map[es.EntityId]es.Events
+	queues        map[string]map[es.EntityId]durable.Message
+	results       map[idempotencyKey]server.IdempotentResult
+}
+
+type Option func(s *store)
+
+// WithUpcasters brings Info of stored events to the current schema version when entities are fetched.
+func WithUpcasters(upcasters *aggregates.Upcasters) Option {
+	return func(s *store) {
+		s.upcasters = upcasters
+	}
 }
 
-func NewPersistence(entityFactory server.EntityFactory) server.Persistence {
-	return &persistence{
+// NewPersistence returns persistence of the default tenant.
+func NewPersistence(entityFactory server.EntityFactory, options ...Option) server.Persistence {
+	s := &store{
 		entityFactory: entityFactory,
-		events:        map[es.EntityType]map[es.EntityId]es.Events{},
+		events:        map[es.TenantId]map[es.EntityType]map[es.EntityId]es.Events{},
+		queues:        map[string]map[es.EntityId]durable.Message{},
+		results:       map[idempotencyKey]server.IdempotentResult{},
+	}
+	for _, option := range options {
+		option(s)
 	}
+	return &persistence{store: s}
+}
+
+func (p *persistence) Tenant(tenantId es.TenantId) server.Persistence {
+	return &persistence{store: p.store, tenantId: tenantId}
 }
 
 func (p *persistence) PersistEvents(events ...es.Event) {
@@ -29,10 +62,22 @@ func (p *persistence) PersistEvents(events ...es.Event) {
 }
 
 func (p *persistence) PersistEvent(event es.Event) {
-	typeEvents, ok := p.events[event.EntityType]
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.persistEventLocked(event)
+}
+
+func (p *persistence) persistEventLocked(event es.Event) {
+	event.Metadata.TenantId = p.tenantId
+	tenantEvents, ok := p.events[p.tenantId]
+	if !ok {
+		tenantEvents = map[es.EntityType]map[es.EntityId]es.Events{}
+		p.events[p.tenantId] = tenantEvents
+	}
+	typeEvents, ok := tenantEvents[event.EntityType]
 	if !ok {
 		typeEvents = map[es.EntityId]es.Events{}
-		p.events[event.EntityType] = typeEvents
+		tenantEvents[event.EntityType] = typeEvents
 	}
 	typeEvents[event.EntityId] = append(typeEvents[event.EntityId], event)
 }
@@ -58,7 +103,7 @@ func (p *persistence) fetchEntity(et es.EntityType, id es.EntityId, filter func(
 	aggr := es.Info{}
 	for _, event := range events {
 		if filter(event) {
-			aggregates.Aggregate(aggr, event.Info)
+			aggregates.Aggregate(aggr, p.upcasters.Upcast(event).Info)
 		}
 	}
 
@@ -68,8 +113,34 @@ func (p *persistence) fetchEntity(et es.EntityType, id es.EntityId, filter func(
 }
 
 func (p *persistence) fetchEntityEvents(et es.EntityType, id es.EntityId) es.Events {
-	if typeEvents, ok := p.events[et]; ok {
-		return typeEvents[id]
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.events[p.tenantId][et][id]
+}
+
+func (p *persistence) FetchCausalChain(correlationId es.EntityId) (es.Events, error) {
+	return p.selectEvents(func(event es.Event) bool { return event.CorrelationId == correlationId }), nil
+}
+
+func (p *persistence) FetchEvents(filter server.EventFilter) (es.Events, error) {
+	return p.selectEvents(filter.Match), nil
+}
+
+func (p *persistence) selectEvents(filter func(es.Event) bool) es.Events {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var result es.Events
+	for _, typeEvents := range p.events[p.tenantId] {
+		for _, events := range typeEvents {
+			for _, event := range events {
+				if filter(event) {
+					result = append(result, event)
+				}
+			}
+		}
 	}
-	return nil
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+	return result
 }
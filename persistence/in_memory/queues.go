@@ -0,0 +1,47 @@
+package in_memory
+
+import (
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/queue/durable"
+)
+
+func (p *persistence) PersistQueueMessage(message durable.Message) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.persistQueueMessageLocked(message)
+	return nil
+}
+
+// PersistEventsWithMessages stores the events and the messages of queues atomically.
+func (p *persistence) PersistEventsWithMessages(messages durable.Messages, events ...es.Event) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, event := range events {
+		p.persistEventLocked(event)
+	}
+	for _, message := range messages {
+		p.persistQueueMessageLocked(message)
+	}
+	return nil
+}
+
+func (p *persistence) persistQueueMessageLocked(message durable.Message) {
+	messages, ok := p.queues[message.Queue]
+	if !ok {
+		messages = map[es.EntityId]durable.Message{}
+		p.queues[message.Queue] = messages
+	}
+	messages[message.MessageId] = message
+}
+
+func (p *persistence) FetchQueueMessages(queue string, state durable.State) (durable.Messages, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var result durable.Messages
+	for _, message := range p.queues[queue] {
+		if message.State == state {
+			result = append(result, message)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,28 @@
+package mongo
+
+import (
+	"time"
+
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/server"
+)
+
+func (env *persistence) ReserveIdempotencyKey(reservation server.IdempotentResult, expiredBefore, leaseExpiredBefore time.Time) (bool, error) {
+	return true, nil
+}
+
+func (env *persistence) PersistIdempotentResult(result server.IdempotentResult) error {
+	return nil
+}
+
+func (env *persistence) FetchIdempotentResult(tenantId es.TenantId, key string) (*server.IdempotentResult, error) {
+	return nil, nil
+}
+
+func (env *persistence) DeleteIdempotentResult(tenantId es.TenantId, key string) error {
+	return nil
+}
+
+func (env *persistence) DeleteIdempotentResults(completedBefore time.Time) error {
+	return nil
+}
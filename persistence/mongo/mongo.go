@@ -3,27 +3,78 @@ package mongo
 import (
 	"time"
 
+	"github.com/andrew-suprun/legion/aggregates"
+	"github.com/andrew-suprun/legion/errors"
 	"github.com/andrew-suprun/legion/es"
 	"github.com/andrew-suprun/legion/server"
 )
 
+// TenancyNotSupported fails operations of tenant views: data of tenants is not separated
+// in Mongo yet, so tenants would read and overwrite each other's entities.
+const TenancyNotSupported errors.ErrorCode = "tenancy_not_supported"
+
 type persistence struct {
+	upcasters *aggregates.Upcasters
+	tenantId  es.TenantId
+}
+
+type Option func(p *persistence)
+
+// WithUpcasters brings Info of stored events to the current schema version when entities are fetched.
+func WithUpcasters(upcasters *aggregates.Upcasters) Option {
+	return func(p *persistence) {
+		p.upcasters = upcasters
+	}
+}
+
+func NewPersistence(connectString string, entityFactory server.EntityFactory, options ...Option) *persistence {
+	p := &persistence{}
+	for _, option := range options {
+		option(p)
+	}
+	return p
 }
 
-func NewPersistence(connectString string, entityFactory server.EntityFactory) *persistence {
-	return &persistence{}
+// Tenant returns the persistence itself for commands without tenant; the views of tenants
+// fail every operation with TenancyNotSupported, writes of events by panicking.
+func (env *persistence) Tenant(tenantId es.TenantId) server.Persistence {
+	if tenantId == "" {
+		return env
+	}
+	return &persistence{upcasters: env.upcasters, tenantId: tenantId}
+}
+
+func (env *persistence) checkTenant() error {
+	if env.tenantId == "" {
+		return nil
+	}
+	return errors.NewError(errors.Failure, TenancyNotSupported, "Mongo persistence does not support tenants.", es.Info{"tenant_id": env.tenantId})
 }
 
 func (env *persistence) PersistEvents(events ...es.Event) {
+	if err := env.checkTenant(); err != nil {
+		panic(err)
+	}
 }
 
 func (env *persistence) PersistEvent(event es.Event) {
+	if err := env.checkTenant(); err != nil {
+		panic(err)
+	}
 }
 
 func (env *persistence) FetchEntity(et es.EntityType, id es.EntityId) (es.Entity, error) {
-	return nil, nil
+	return nil, env.checkTenant()
 }
 
 func (env *persistence) FetchEntityAt(et es.EntityType, id es.EntityId, timestamp time.Time) (es.Entity, error) {
-	return nil, nil
+	return nil, env.checkTenant()
+}
+
+func (env *persistence) FetchCausalChain(correlationId es.EntityId) (es.Events, error) {
+	return nil, env.checkTenant()
+}
+
+func (env *persistence) FetchEvents(filter server.EventFilter) (es.Events, error) {
+	return nil, env.checkTenant()
 }
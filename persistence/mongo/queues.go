@@ -0,0 +1,18 @@
+package mongo
+
+import (
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/queue/durable"
+)
+
+func (env *persistence) PersistQueueMessage(message durable.Message) error {
+	return nil
+}
+
+func (env *persistence) FetchQueueMessages(queue string, state durable.State) (durable.Messages, error) {
+	return nil, nil
+}
+
+func (env *persistence) PersistEventsWithMessages(messages durable.Messages, events ...es.Event) error {
+	return env.checkTenant()
+}
@@ -0,0 +1,419 @@
+package durable
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/queue"
+)
+
+const UnknownMessage errors.ErrorCode = "unknown_queue_message"
+
+type State string
+
+const (
+	Pending      State = "pending"
+	Acked        State = "acked"
+	DeadLettered State = "dead_lettered"
+)
+
+type Message struct {
+	MessageId es.EntityId `json:"message_id" bson:"message_id"`
+	Queue     string      `json:"queue" bson:"queue"`
+	State     State       `json:"state" bson:"state"`
+	Payload   interface{} `json:"payload,omitempty" bson:"payload,omitempty"`
+	Priority  int         `json:"priority" bson:"priority"`
+	Seq       int64       `json:"seq" bson:"seq"`
+	Attempts  int         `json:"attempts" bson:"attempts"`
+	VisibleAt time.Time   `json:"visible_at" bson:"visible_at"`
+	Timestamp time.Time   `json:"timestamp" bson:"timestamp"`
+}
+
+type Messages []Message
+
+// Persistence stores queue messages; PersistQueueMessage replaces the message with the same id.
+type Persistence interface {
+	PersistQueueMessage(message Message) error
+	FetchQueueMessages(queue string, state State) (Messages, error)
+}
+
+// Delivery is returned by Get; it has to be acked, otherwise the message is delivered again
+// after visibility timeout.
+type Delivery struct {
+	MessageId es.EntityId
+	Payload   interface{}
+	Attempts  int
+	queue     *Queue
+}
+
+func (d Delivery) Ack() error {
+	return d.queue.Ack(d.MessageId)
+}
+
+func (d Delivery) Nack() error {
+	return d.queue.Nack(d.MessageId)
+}
+
+type Queue struct {
+	lock        sync.Mutex
+	name        string
+	persistence Persistence
+	clock       queue.Clock
+	visibility  time.Duration
+	maxAttempts int
+	pending     map[es.EntityId]*Message
+	seq         int64
+	stats       queue.Stats
+	changed     chan bool
+	closed      bool
+}
+
+var _ queue.Interface = (*Queue)(nil)
+
+type Option func(q *Queue)
+
+func WithClock(clock queue.Clock) Option {
+	return func(q *Queue) {
+		q.clock = clock
+	}
+}
+
+// WithVisibilityTimeout sets how long delivered message stays invisible before it is delivered again.
+func WithVisibilityTimeout(timeout time.Duration) Option {
+	return func(q *Queue) {
+		q.visibility = timeout
+	}
+}
+
+// WithMaxAttempts sets number of deliveries after which unacknowledged message is dead-lettered.
+func WithMaxAttempts(attempts int) Option {
+	return func(q *Queue) {
+		q.maxAttempts = attempts
+	}
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// New opens the named queue and recovers its pending messages.
+func New(name string, persistence Persistence, options ...Option) (*Queue, error) {
+	q := &Queue{
+		name:        name,
+		persistence: persistence,
+		clock:       systemClock{},
+		visibility:  30 * time.Second,
+		maxAttempts: 5,
+		pending:     map[es.EntityId]*Message{},
+		changed:     make(chan bool),
+	}
+	for _, option := range options {
+		option(q)
+	}
+
+	messages, err := persistence.FetchQueueMessages(name, Pending)
+	if err != nil {
+		return nil, err
+	}
+	for i := range messages {
+		m := messages[i]
+		q.pending[m.MessageId] = &m
+		if q.seq < m.Seq {
+			q.seq = m.Seq
+		}
+	}
+	return q, nil
+}
+
+func (q *Queue) Put(payload interface{}, options ...queue.PutOption) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		return queue.Closed
+	}
+
+	m := q.newMessageLocked(payload, options)
+	if err := q.persistence.PersistQueueMessage(m); err != nil {
+		return err
+	}
+	q.enqueueLocked(m)
+	return nil
+}
+
+// Prepare returns the message for the payload without persisting it, so that the caller can persist it
+// in the same write as its own records. The queue delivers the message after Enqueue; messages persisted
+// but not enqueued, e.g. because of a crash, are recovered by New.
+func (q *Queue) Prepare(payload interface{}, options ...queue.PutOption) Message {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.newMessageLocked(payload, options)
+}
+
+// Enqueue delivers the message returned by Prepare after the caller persisted it.
+func (q *Queue) Enqueue(message Message) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		return queue.Closed
+	}
+	q.enqueueLocked(message)
+	return nil
+}
+
+func (q *Queue) newMessageLocked(payload interface{}, options []queue.PutOption) Message {
+	priority, notBefore := queue.PutOptions(options...)
+	now := q.clock.Now()
+	if notBefore.Before(now) {
+		notBefore = now
+	}
+	q.seq++
+	return Message{
+		MessageId: es.NewEntityId(),
+		Queue:     q.name,
+		State:     Pending,
+		Payload:   payload,
+		Priority:  priority,
+		Seq:       q.seq,
+		VisibleAt: notBefore,
+		Timestamp: now,
+	}
+}
+
+func (q *Queue) enqueueLocked(m Message) {
+	q.pending[m.MessageId] = &m
+	q.stats.Put++
+	q.notifyLocked()
+}
+
+func (q *Queue) notifyLocked() {
+	close(q.changed)
+	q.changed = make(chan bool)
+}
+
+// Refresh makes waiting readers re-check invisible messages, for clocks that can be moved forward.
+func (q *Queue) Refresh() {
+	q.lock.Lock()
+	q.notifyLocked()
+	q.lock.Unlock()
+}
+
+// Get returns Delivery of the next visible message.
+func (q *Queue) Get() (interface{}, error) {
+	return q.GetContext(context.Background())
+}
+
+func (q *Queue) GetContext(ctx context.Context) (interface{}, error) {
+	batch, err := q.GetBatchContext(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+	return batch[0], nil
+}
+
+func (q *Queue) TryGet() (interface{}, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	batch, err := q.deliverLocked(1)
+	if err != nil {
+		return nil, err
+	}
+	if len(batch) > 0 {
+		return batch[0], nil
+	}
+	if q.closed {
+		return nil, queue.Closed
+	}
+	return nil, queue.Empty
+}
+
+func (q *Queue) GetBatch(max int) ([]interface{}, error) {
+	return q.GetBatchContext(context.Background(), max)
+}
+
+// GetBatchContext returns Deliveries of up to max visible messages.
+// After Close it returns messages visible at the moment and then queue.Closed;
+// messages in flight stay persisted for the next run.
+func (q *Queue) GetBatchContext(ctx context.Context, max int) ([]interface{}, error) {
+	for {
+		q.lock.Lock()
+		batch, err := q.deliverLocked(max)
+		closed := q.closed
+		changed := q.changed
+		wait := q.nextVisibleLocked()
+		q.lock.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+		if len(batch) > 0 {
+			return batch, nil
+		}
+		if closed {
+			return nil, queue.Closed
+		}
+
+		var timer *time.Timer
+		var due <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			due = timer.C
+		}
+
+		select {
+		case <-changed:
+		case <-due:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (q *Queue) deliverLocked(max int) ([]interface{}, error) {
+	now := q.clock.Now()
+	var visible []*Message
+	for _, m := range q.pending {
+		if !m.VisibleAt.After(now) {
+			visible = append(visible, m)
+		}
+	}
+	sort.Slice(visible, func(i, j int) bool {
+		if visible[i].Priority != visible[j].Priority {
+			return visible[i].Priority > visible[j].Priority
+		}
+		return visible[i].Seq < visible[j].Seq
+	})
+
+	var batch []interface{}
+	for _, m := range visible {
+		if len(batch) >= max {
+			break
+		}
+		if m.Attempts >= q.maxAttempts {
+			if err := q.deadLetterLocked(m); err != nil {
+				return batch, err
+			}
+			continue
+		}
+		delivered := *m
+		delivered.Attempts++
+		delivered.VisibleAt = now.Add(q.visibility)
+		if err := q.persistence.PersistQueueMessage(delivered); err != nil {
+			return batch, err
+		}
+		*m = delivered
+		q.stats.Got++
+		batch = append(batch, Delivery{MessageId: m.MessageId, Payload: m.Payload, Attempts: m.Attempts, queue: q})
+	}
+	return batch, nil
+}
+
+func (q *Queue) nextVisibleLocked() time.Duration {
+	var next time.Time
+	for _, m := range q.pending {
+		if next.IsZero() || m.VisibleAt.Before(next) {
+			next = m.VisibleAt
+		}
+	}
+	if next.IsZero() {
+		return 0
+	}
+	return next.Sub(q.clock.Now())
+}
+
+func (q *Queue) deadLetterLocked(m *Message) error {
+	deadLetter := *m
+	deadLetter.State = DeadLettered
+	if err := q.persistence.PersistQueueMessage(deadLetter); err != nil {
+		return err
+	}
+	delete(q.pending, m.MessageId)
+	q.stats.Dropped++
+	return nil
+}
+
+func (q *Queue) Ack(id es.EntityId) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	m, ok := q.pending[id]
+	if !ok {
+		return errors.NewError(errors.Failure, UnknownMessage, "Message is not pending.", es.Info{"message_id": id})
+	}
+	acked := *m
+	acked.State = Acked
+	if err := q.persistence.PersistQueueMessage(acked); err != nil {
+		return err
+	}
+	delete(q.pending, id)
+	return nil
+}
+
+// Nack makes the message visible again right away, or dead-letters it if it ran out of attempts.
+func (q *Queue) Nack(id es.EntityId) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	m, ok := q.pending[id]
+	if !ok {
+		return errors.NewError(errors.Failure, UnknownMessage, "Message is not pending.", es.Info{"message_id": id})
+	}
+	if m.Attempts >= q.maxAttempts {
+		return q.deadLetterLocked(m)
+	}
+	nacked := *m
+	nacked.VisibleAt = q.clock.Now()
+	if err := q.persistence.PersistQueueMessage(nacked); err != nil {
+		return err
+	}
+	*m = nacked
+	q.notifyLocked()
+	return nil
+}
+
+func (q *Queue) DeadLetters() (Messages, error) {
+	return q.persistence.FetchQueueMessages(q.name, DeadLettered)
+}
+
+// Len returns the number of pending messages including ones in flight.
+func (q *Queue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.pending)
+}
+
+func (q *Queue) Stats() queue.Stats {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	stats := q.stats
+	stats.Len = len(q.pending)
+	now := q.clock.Now()
+	for _, m := range q.pending {
+		if m.VisibleAt.After(now) {
+			stats.Delayed++
+		}
+	}
+	return stats
+}
+
+func (q *Queue) Close() {
+	q.lock.Lock()
+	if !q.closed {
+		q.closed = true
+		q.notifyLocked()
+	}
+	q.lock.Unlock()
+}
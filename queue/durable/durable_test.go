@@ -0,0 +1,121 @@
+package durable
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/queue"
+)
+
+type testPersistence struct {
+	lock     sync.Mutex
+	messages map[es.EntityId]Message
+}
+
+func (p *testPersistence) PersistQueueMessage(message Message) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.messages[message.MessageId] = message
+	return nil
+}
+
+func (p *testPersistence) FetchQueueMessages(queue string, state State) (Messages, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var result Messages
+	for _, message := range p.messages {
+		if message.Queue == queue && message.State == state {
+			result = append(result, message)
+		}
+	}
+	return result, nil
+}
+
+type testClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.lock.Lock()
+	c.now = c.now.Add(d)
+	c.lock.Unlock()
+}
+
+func TestRedeliveryAndDeadLetter(t *testing.T) {
+	p := &testPersistence{messages: map[es.EntityId]Message{}}
+	clock := &testClock{now: time.Now()}
+	q, _ := New("test", p, WithClock(clock), WithVisibilityTimeout(time.Minute), WithMaxAttempts(2))
+	q.Put("msg")
+
+	first, _ := q.TryGet()
+	if first.(Delivery).Payload != "msg" {
+		t.Fatalf("Unexpected delivery %v", first)
+	}
+	if _, err := q.TryGet(); err != queue.Empty {
+		t.Fatalf("Expected message to be invisible, got %v", err)
+	}
+
+	clock.Add(time.Minute)
+	second, _ := q.TryGet()
+	if second.(Delivery).Attempts != 2 {
+		t.Fatalf("Expected redelivery, got %v", second)
+	}
+
+	clock.Add(time.Minute)
+	if _, err := q.TryGet(); err != queue.Empty {
+		t.Fatalf("Expected message to be dead-lettered, got %v", err)
+	}
+	deadLetters, _ := q.DeadLetters()
+	if len(deadLetters) != 1 || q.Len() != 0 {
+		t.Fatalf("Expected one dead letter, got %v", deadLetters)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	p := &testPersistence{messages: map[es.EntityId]Message{}}
+	q, _ := New("test", p)
+	q.Put("first")
+	q.Put("second")
+	delivery, _ := q.Get()
+	delivery.(Delivery).Ack()
+	q.Close()
+
+	q, _ = New("test", p)
+	delivery, _ = q.TryGet()
+	if delivery.(Delivery).Payload != "second" {
+		t.Fatalf("Expected unacked message to survive restart, got %v", delivery)
+	}
+}
+
+func TestPrepareAndEnqueue(t *testing.T) {
+	p := &testPersistence{messages: map[es.EntityId]Message{}}
+	q, _ := New("test", p)
+	prepared := q.Prepare("first")
+	if _, err := q.TryGet(); err != queue.Empty {
+		t.Fatalf("Expected prepared message not to be delivered before Enqueue, got %v", err)
+	}
+	p.PersistQueueMessage(prepared)
+	q.Enqueue(prepared)
+	delivery, _ := q.TryGet()
+	if delivery.(Delivery).Payload != "first" {
+		t.Fatalf("Expected enqueued message, got %v", delivery)
+	}
+	delivery.(Delivery).Ack()
+
+	p.PersistQueueMessage(q.Prepare("second"))
+	q.Close()
+	q, _ = New("test", p)
+	delivery, _ = q.TryGet()
+	if delivery.(Delivery).Payload != "second" {
+		t.Fatalf("Expected persisted message to be recovered, got %v", delivery)
+	}
+}
@@ -1,56 +1,416 @@
 package queue
 
 import (
+	"container/heap"
+	"context"
 	"errors"
+	"sort"
 	"sync"
+	"time"
 )
 
 type Queue struct {
-	pending []interface{}
-	*sync.Cond
-	closed bool
+	lock       sync.Mutex
+	clock      Clock
+	capacity   int
+	policy     Policy
+	onClose    ClosePolicy
+	ready      map[int]*Ring
+	priorities []int // priorities of ready messages, highest first
+	delayed    delayedMessages
+	count      int
+	seq        uint64
+	stats      Stats
+	notFull    *sync.Cond
+	changed    chan bool // closed and replaced whenever a message is put or the queue is closed
+	closed     bool
+	chanOut    chan interface{}
+	chanRun    sync.Once
+}
+
+// Clock is satisfied by server.TimeService, so that tests can fast-forward delayed messages.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Policy defines what Put does when a bounded queue is full.
+type Policy int
+
+const (
+	Block      Policy = iota // wait until there is room
+	DropOldest               // discard the oldest message of the lowest priority to make room
+	DropNewest               // discard the message being put
+	Reject                   // return Full
+)
+
+// ClosePolicy defines what happens to delayed messages when the queue is closed.
+type ClosePolicy int
+
+const (
+	DrainDelayed   ClosePolicy = iota // deliver delayed messages when due before reporting Closed
+	DiscardDelayed                    // drop delayed messages on Close
+)
+
+type Stats struct {
+	Len      int    `json:"len"`
+	Delayed  int    `json:"delayed"`
+	Capacity int    `json:"capacity"`
+	Put      uint64 `json:"put"`
+	Got      uint64 `json:"got"`
+	Dropped  uint64 `json:"dropped"`
+	Rejected uint64 `json:"rejected"`
 }
 
 var Closed = errors.New("Reading from closed queue.")
+var Full = errors.New("Queue is full.")
+var Empty = errors.New("Queue is empty.")
+
+type Option func(q *Queue)
 
-func New() *Queue {
-	return &Queue{Cond: sync.NewCond(&sync.Mutex{})}
+func WithClock(clock Clock) Option {
+	return func(q *Queue) {
+		q.clock = clock
+	}
 }
 
-func (q *Queue) Put(msg interface{}) {
-	q.Cond.L.Lock()
+func WithClosePolicy(policy ClosePolicy) Option {
+	return func(q *Queue) {
+		q.onClose = policy
+	}
+}
 
-	if q.closed {
-		q.Cond.L.Unlock()
-		return
+type PutOption func(m *message)
+
+// Priority of the message; messages with higher priority are delivered first, default priority is zero.
+func Priority(priority int) PutOption {
+	return func(m *message) {
+		m.priority = priority
+	}
+}
+
+// NotBefore delays delivery of the message until the clock of the queue passes the timestamp.
+func NotBefore(timestamp time.Time) PutOption {
+	return func(m *message) {
+		m.notBefore = timestamp
 	}
+}
+
+type message struct {
+	msg       interface{}
+	priority  int
+	notBefore time.Time
+	seq       uint64
+}
+
+func New(options ...Option) *Queue {
+	return NewBounded(0, Block, options...)
+}
 
-	q.pending = append(q.pending, msg)
-	q.Cond.Signal()
-	q.Cond.L.Unlock()
+// NewBounded creates a queue holding at most capacity messages including delayed ones; zero capacity means unbounded.
+func NewBounded(capacity int, policy Policy, options ...Option) *Queue {
+	q := &Queue{
+		clock:    systemClock{},
+		capacity: capacity,
+		policy:   policy,
+		ready:    map[int]*Ring{},
+		changed:  make(chan bool),
+	}
+	q.notFull = sync.NewCond(&q.lock)
+	for _, option := range options {
+		option(q)
+	}
+	return q
 }
 
-func (q *Queue) Get() (msg interface{}) {
-	q.Cond.L.Lock()
+func (q *Queue) Put(msg interface{}, options ...PutOption) error {
+	m := &message{msg: msg}
+	for _, option := range options {
+		option(m)
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
 
-	for !q.closed && len(q.pending) == 0 {
-		q.Cond.Wait()
+	for !q.closed && q.fullLocked() && q.policy == Block {
+		q.notFull.Wait()
 	}
 
-	if len(q.pending) == 0 && q.closed {
-		q.Cond.L.Unlock()
+	if q.closed {
 		return Closed
 	}
 
-	msg = q.pending[0]
-	q.pending = q.pending[1:]
-	q.Cond.L.Unlock()
-	return msg
+	if q.fullLocked() {
+		switch q.policy {
+		case DropOldest:
+			q.dropOldestLocked()
+		case DropNewest:
+			q.stats.Dropped++
+			return nil
+		default:
+			q.stats.Rejected++
+			return Full
+		}
+	}
+
+	q.seq++
+	m.seq = q.seq
+	if m.notBefore.After(q.clock.Now()) {
+		heap.Push(&q.delayed, m)
+	} else {
+		q.pushReadyLocked(m)
+	}
+	q.count++
+	q.stats.Put++
+	q.notifyLocked()
+	return nil
+}
+
+func (q *Queue) fullLocked() bool {
+	return q.capacity > 0 && q.count >= q.capacity
+}
+
+func (q *Queue) pushReadyLocked(m *message) {
+	ring, ok := q.ready[m.priority]
+	if !ok {
+		ring = NewRing(0)
+		q.ready[m.priority] = ring
+		q.priorities = append(q.priorities, m.priority)
+		sort.Sort(sort.Reverse(sort.IntSlice(q.priorities)))
+	}
+	ring.Push(m.msg)
+}
+
+func (q *Queue) dropOldestLocked() {
+	for i := len(q.priorities) - 1; i >= 0; i-- {
+		if _, ok := q.ready[q.priorities[i]].Pop(); ok {
+			q.count--
+			q.stats.Dropped++
+			return
+		}
+	}
+	if q.delayed.Len() > 0 {
+		heap.Pop(&q.delayed)
+		q.count--
+		q.stats.Dropped++
+	}
+}
+
+func (q *Queue) notifyLocked() {
+	close(q.changed)
+	q.changed = make(chan bool)
+}
+
+// Refresh makes waiting readers re-check delayed messages, for clocks that can be moved forward.
+func (q *Queue) Refresh() {
+	q.lock.Lock()
+	q.notifyLocked()
+	q.lock.Unlock()
+}
+
+// Get blocks until there is a message; returns Closed once the queue is closed and drained.
+func (q *Queue) Get() (interface{}, error) {
+	return q.GetContext(context.Background())
+}
+
+func (q *Queue) GetContext(ctx context.Context) (interface{}, error) {
+	batch, err := q.GetBatchContext(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+	return batch[0], nil
+}
+
+// TryGet returns Empty instead of blocking.
+func (q *Queue) TryGet() (interface{}, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	batch := q.popLocked(1)
+	if len(batch) > 0 {
+		return batch[0], nil
+	}
+	if q.closed && q.count == 0 {
+		return nil, Closed
+	}
+	return nil, Empty
+}
+
+// GetBatch blocks until there is at least one message and returns up to max messages.
+func (q *Queue) GetBatch(max int) ([]interface{}, error) {
+	return q.GetBatchContext(context.Background(), max)
+}
+
+func (q *Queue) GetBatchContext(ctx context.Context, max int) (batch []interface{}, err error) {
+	for {
+		q.lock.Lock()
+		batch = q.popLocked(max)
+		finished := q.closed && q.count == 0
+		changed := q.changed
+		var wait time.Duration
+		if len(q.delayed) > 0 {
+			wait = q.delayed[0].notBefore.Sub(q.clock.Now())
+		}
+		q.lock.Unlock()
+
+		if len(batch) > 0 {
+			return batch, nil
+		}
+		if finished {
+			return nil, Closed
+		}
+
+		var timer *time.Timer
+		var due <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			due = timer.C
+		}
+
+		select {
+		case <-changed:
+		case <-due:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (q *Queue) popLocked(max int) []interface{} {
+	now := q.clock.Now()
+	for len(q.delayed) > 0 && !q.delayed[0].notBefore.After(now) {
+		q.pushReadyLocked(heap.Pop(&q.delayed).(*message))
+	}
+
+	var batch []interface{}
+	for _, priority := range q.priorities {
+		ring := q.ready[priority]
+		for len(batch) < max {
+			msg, ok := ring.Pop()
+			if !ok {
+				break
+			}
+			batch = append(batch, msg)
+		}
+	}
+	if len(batch) > 0 {
+		q.count -= len(batch)
+		q.stats.Got += uint64(len(batch))
+		q.notFull.Broadcast()
+	}
+	return batch
+}
+
+// Chan returns a channel for use in select statements; the channel is closed after the queue is closed and drained.
+// The channel holds one message taken from the queue until it is received.
+func (q *Queue) Chan() <-chan interface{} {
+	q.chanRun.Do(func() {
+		q.chanOut = make(chan interface{})
+		go func() {
+			for {
+				msg, err := q.Get()
+				if err != nil {
+					close(q.chanOut)
+					return
+				}
+				q.chanOut <- msg
+			}
+		}()
+	})
+	return q.chanOut
+}
+
+// Len returns the number of pending messages including delayed ones.
+func (q *Queue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.count
+}
+
+func (q *Queue) Stats() Stats {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	stats := q.stats
+	stats.Len = q.count
+	stats.Delayed = len(q.delayed)
+	stats.Capacity = q.capacity
+	return stats
 }
 
 func (q *Queue) Close() {
-	q.Cond.L.Lock()
-	q.closed = true
-	q.Cond.Broadcast()
-	q.Cond.L.Unlock()
+	q.lock.Lock()
+	if !q.closed {
+		q.closed = true
+		if q.onClose == DiscardDelayed {
+			q.count -= len(q.delayed)
+			q.stats.Dropped += uint64(len(q.delayed))
+			q.delayed = nil
+		}
+		q.notifyLocked()
+		q.notFull.Broadcast()
+	}
+	q.lock.Unlock()
+}
+
+type delayedMessages []*message
+
+func (d delayedMessages) Len() int {
+	return len(d)
+}
+
+func (d delayedMessages) Less(i, j int) bool {
+	if d[i].notBefore.Equal(d[j].notBefore) {
+		return d[i].seq < d[j].seq
+	}
+	return d[i].notBefore.Before(d[j].notBefore)
+}
+
+func (d delayedMessages) Swap(i, j int) {
+	d[i], d[j] = d[j], d[i]
+}
+
+func (d *delayedMessages) Push(x interface{}) {
+	*d = append(*d, x.(*message))
+}
+
+func (d *delayedMessages) Pop() interface{} {
+	old := *d
+	m := old[len(old)-1]
+	old[len(old)-1] = nil
+	*d = old[:len(old)-1]
+	return m
+}
+
+// Interface is implemented by both in-memory and durable queues.
+type Interface interface {
+	Put(msg interface{}, options ...PutOption) error
+	Get() (interface{}, error)
+	GetContext(ctx context.Context) (interface{}, error)
+	TryGet() (interface{}, error)
+	GetBatch(max int) ([]interface{}, error)
+	GetBatchContext(ctx context.Context, max int) ([]interface{}, error)
+	Len() int
+	Stats() Stats
+	Close()
+}
+
+var _ Interface = (*Queue)(nil)
+
+// PutOptions returns priority and delivery time set by options, for Interface implementations outside of this package.
+func PutOptions(options ...PutOption) (priority int, notBefore time.Time) {
+	m := &message{}
+	for _, option := range options {
+		option(m)
+	}
+	return m.priority, m.notBefore
 }
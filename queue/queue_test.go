@@ -0,0 +1,185 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRing(t *testing.T) {
+	r := NewRing(0)
+	for i := 0; i < 100; i++ {
+		r.Push(i)
+	}
+	for i := 0; i < 100; i++ {
+		if item, _ := r.Pop(); item != i {
+			t.Fatalf("Expected %d, got %v", i, item)
+		}
+	}
+	if len(r.items) != minRingSize {
+		t.Fatalf("Expected drained ring to shrink, got %d", len(r.items))
+	}
+
+	r = NewRing(2)
+	if !r.Push(1) || !r.Push(2) || r.Push(3) {
+		t.Fatalf("Expected ring to be bounded.")
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	q := NewBounded(2, DropOldest)
+	q.Put(1)
+	q.Put(2)
+	q.Put(3)
+	if msg, _ := q.Get(); msg != 2 {
+		t.Fatalf("Expected 2, got %v", msg)
+	}
+
+	q = NewBounded(2, DropNewest)
+	q.Put(1)
+	q.Put(2)
+	q.Put(3)
+	if msg, _ := q.Get(); msg != 1 || q.Len() != 1 {
+		t.Fatalf("Expected 1, got %v", msg)
+	}
+
+	q = NewBounded(1, Reject)
+	q.Put(1)
+	if err := q.Put(2); err != Full {
+		t.Fatalf("Expected Full, got %v", err)
+	}
+	if stats := q.Stats(); stats.Rejected != 1 || stats.Len != 1 || stats.Capacity != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestBlock(t *testing.T) {
+	q := NewBounded(1, Block)
+	q.Put(1)
+	done := make(chan bool)
+	go func() {
+		q.Put(2)
+		done <- true
+	}()
+
+	select {
+	case <-done:
+		t.Fatalf("Expected Put to block.")
+	case <-time.After(10 * time.Millisecond):
+	}
+	q.Get()
+	<-done
+	if msg, _ := q.Get(); msg != 2 {
+		t.Fatalf("Expected 2, got %v", msg)
+	}
+}
+
+func TestGetContext(t *testing.T) {
+	q := New()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, err := q.GetContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected deadline exceeded, got %v", err)
+	}
+	if _, err := q.TryGet(); err != Empty {
+		t.Fatalf("Expected Empty, got %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		q.Put(i)
+	}
+	batch, err := q.GetBatch(3)
+	if err != nil || len(batch) != 3 || batch[2] != 2 {
+		t.Fatalf("Unexpected batch %v, %v", batch, err)
+	}
+
+	q.Close()
+	var received []interface{}
+	for msg := range q.Chan() {
+		received = append(received, msg)
+	}
+	if len(received) != 2 {
+		t.Fatalf("Expected remaining messages to be drained, got %v", received)
+	}
+	if _, err := q.Get(); err != Closed {
+		t.Fatalf("Expected Closed, got %v", err)
+	}
+}
+
+type testClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.lock.Lock()
+	c.now = c.now.Add(d)
+	c.lock.Unlock()
+}
+
+func TestPriority(t *testing.T) {
+	q := New()
+	q.Put("low", Priority(-1))
+	q.Put("normal")
+	q.Put("high", Priority(1))
+	batch, _ := q.GetBatch(3)
+	if batch[0] != "high" || batch[1] != "normal" || batch[2] != "low" {
+		t.Fatalf("Unexpected order %v", batch)
+	}
+}
+
+func TestDelayed(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	q := New(WithClock(clock))
+	q.Put("later", NotBefore(clock.now.Add(time.Hour)))
+	q.Put("now")
+
+	if msg, _ := q.Get(); msg != "now" {
+		t.Fatalf("Expected 'now', got %v", msg)
+	}
+	if _, err := q.TryGet(); err != Empty {
+		t.Fatalf("Expected delayed message not to be delivered, got %v", err)
+	}
+
+	received := make(chan interface{})
+	go func() {
+		msg, _ := q.Get()
+		received <- msg
+	}()
+	clock.Add(time.Hour)
+	q.Refresh()
+	if msg := <-received; msg != "later" {
+		t.Fatalf("Expected 'later', got %v", msg)
+	}
+}
+
+func TestCloseDelayed(t *testing.T) {
+	clock := &testClock{now: time.Now()}
+	q := New(WithClock(clock), WithClosePolicy(DiscardDelayed))
+	q.Put("later", NotBefore(clock.now.Add(time.Hour)))
+	q.Close()
+	if _, err := q.TryGet(); err != Closed {
+		t.Fatalf("Expected delayed message to be discarded, got %v", err)
+	}
+
+	q = New(WithClock(clock))
+	q.Put("later", NotBefore(clock.now.Add(time.Hour)))
+	q.Close()
+	if _, err := q.TryGet(); err != Empty {
+		t.Fatalf("Expected delayed message to be kept, got %v", err)
+	}
+	clock.Add(time.Hour)
+	if msg, _ := q.Get(); msg != "later" {
+		t.Fatalf("Expected 'later', got %v", msg)
+	}
+	if _, err := q.Get(); err != Closed {
+		t.Fatalf("Expected Closed, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package queue
+
+const minRingSize = 16
+
+// Ring is a FIFO ring buffer. Ring with zero capacity grows as needed
+// and shrinks back when mostly empty, so drained buffers release their memory.
+type Ring struct {
+	items    []interface{}
+	head     int
+	size     int
+	capacity int
+}
+
+func NewRing(capacity int) *Ring {
+	size := capacity
+	if size <= 0 {
+		size = minRingSize
+	}
+	return &Ring{items: make([]interface{}, size), capacity: capacity}
+}
+
+func (r *Ring) Len() int {
+	return r.size
+}
+
+func (r *Ring) Cap() int {
+	return r.capacity
+}
+
+func (r *Ring) Full() bool {
+	return r.capacity > 0 && r.size >= r.capacity
+}
+
+// Push returns false if the ring is full.
+func (r *Ring) Push(item interface{}) bool {
+	if r.Full() {
+		return false
+	}
+	if r.size == len(r.items) {
+		r.resize(2 * len(r.items))
+	}
+	r.items[(r.head+r.size)%len(r.items)] = item
+	r.size++
+	return true
+}
+
+func (r *Ring) Peek() (interface{}, bool) {
+	if r.size == 0 {
+		return nil, false
+	}
+	return r.items[r.head], true
+}
+
+func (r *Ring) Pop() (interface{}, bool) {
+	if r.size == 0 {
+		return nil, false
+	}
+	item := r.items[r.head]
+	r.items[r.head] = nil
+	r.head = (r.head + 1) % len(r.items)
+	r.size--
+	if r.capacity <= 0 && len(r.items) > minRingSize && r.size < len(r.items)/4 {
+		r.resize(len(r.items) / 2)
+	}
+	return item, true
+}
+
+// Drain removes and returns all the items.
+func (r *Ring) Drain() []interface{} {
+	result := make([]interface{}, 0, r.size)
+	for r.size > 0 {
+		item, _ := r.Pop()
+		result = append(result, item)
+	}
+	return result
+}
+
+func (r *Ring) resize(size int) {
+	items := make([]interface{}, size)
+	for i := 0; i < r.size; i++ {
+		items[i] = r.items[(r.head+i)%len(r.items)]
+	}
+	r.items = items
+	r.head = 0
+}
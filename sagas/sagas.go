@@ -0,0 +1,524 @@
+package sagas
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/server"
+)
+
+const (
+	SagaNotFound    errors.ErrorCode = "saga_not_found"
+	UnknownSagaType errors.ErrorCode = "unknown_saga_type"
+)
+
+const TimeoutCommand es.CommandType = "saga_timeout"
+
+type Status string
+
+const (
+	Active       Status = "active"
+	Completed    Status = "completed"
+	Compensating Status = "compensating"
+	Compensated  Status = "compensated"
+)
+
+const (
+	stepIssued             = "issued"
+	stepSucceeded          = "succeeded"
+	stepFailed             = "failed"
+	stepCompensating       = "compensating"
+	stepCompensated        = "compensated"
+	stepCompensationFailed = "compensation_failed"
+)
+
+// State is embedded into saga entities. Steps keep issued commands and their compensations,
+// so that commands issued before a restart can be issued again by Resume.
+// CorrelationId is the correlation of the command that started the saga and Principal is
+// its principal; commands issued by the saga are served on behalf of that principal.
+type State struct {
+	SagaId        es.EntityId      `json:"saga_id"`
+	SagaType      es.EntityType    `json:"saga_type"`
+	CorrelationId es.EntityId      `json:"correlation_id"`
+	Principal     server.Principal `json:"principal"`
+	Status        Status           `json:"status"`
+	StepCount     int              `json:"step_count"`
+	Steps         es.Info          `json:"steps,omitempty"`
+}
+
+func (s *State) EntityId() es.EntityId {
+	return s.SagaId
+}
+
+func (s *State) EntityType() es.EntityType {
+	return s.SagaType
+}
+
+func (s *State) SagaState() *State {
+	return s
+}
+
+type Saga interface {
+	es.Entity
+	SagaState() *State
+	Handle(ctx Context, event es.Event) error
+	Timeout(ctx Context, name string) error
+}
+
+type Command struct {
+	CommandType es.CommandType `json:"command_type"`
+	Info        es.Info        `json:"info,omitempty"`
+}
+
+// Context is what saga uses to react; commands are issued after the saga state is persisted.
+type Context interface {
+	Now() time.Time
+	CorrelationId() es.EntityId
+	Issue(command Command)
+	IssueCompensable(command, compensation Command)
+	ScheduleTimeout(name string, due time.Time)
+	Complete()
+}
+
+// Definition describes a saga type. Correlate maps an event to the id of the saga it belongs to;
+// events for sagas that do not exist yet are ignored unless Starts returns true.
+type Definition struct {
+	SagaType  es.EntityType
+	New       func(id es.EntityId) Saga
+	Correlate func(event es.Event) (correlationId es.EntityId, ok bool)
+	Starts    func(event es.Event) bool
+}
+
+type Manager struct {
+	server      *server.Server
+	definitions map[es.EntityType]*Definition
+	wg          sync.WaitGroup
+}
+
+// NewManager is wired into the server like this:
+//
+//	m := sagas.NewManager(definitions...)
+//	p := in_memory.NewPersistence(m.EntityFactory(entityFactory))
+//	s := server.New(ts, p, m.CommandFactory(commandFactory), server.WithReactor(m.React), server.WithScheduler(p.(durable.Persistence)))
+//	m.Attach(s)
+//	m.Resume(p)
+func NewManager(definitions ...Definition) *Manager {
+	m := &Manager{definitions: map[es.EntityType]*Definition{}}
+	for i := range definitions {
+		m.definitions[definitions[i].SagaType] = &definitions[i]
+	}
+	return m
+}
+
+func (m *Manager) Attach(s *server.Server) {
+	m.server = s
+}
+
+// Wait blocks until all the commands issued by sagas are served; used by tests.
+func (m *Manager) Wait() {
+	m.wg.Wait()
+}
+
+func (m *Manager) EntityFactory(next server.EntityFactory) server.EntityFactory {
+	return func(et es.EntityType, id es.EntityId) (es.Entity, error) {
+		if def, ok := m.definitions[et]; ok {
+			return def.New(id), nil
+		}
+		return next(et, id)
+	}
+}
+
+func (m *Manager) CommandFactory(next server.CommandFactory) server.CommandFactory {
+	return func(cmdType es.CommandType, info es.Info) (server.Command, error) {
+		if cmdType != TimeoutCommand {
+			return next(cmdType, info)
+		}
+		sagaType, _ := info["saga_type"].(string)
+		sagaId, _ := info["saga_id"].(string)
+		name, _ := info["name"].(string)
+		def, ok := m.definitions[es.EntityType(sagaType)]
+		if !ok {
+			return nil, errors.NewError(errors.Failure, UnknownSagaType, "Unknown saga type.", es.Info{"saga_type": sagaType})
+		}
+		return &timeoutCommand{sagaCommand: sagaCommand{def: def, sagaId: es.EntityId(sagaId)}, name: name}, nil
+	}
+}
+
+// Resume issues again commands of the sagas of the tenant that were issued before a restart
+// but whose outcome was not recorded, including compensations; it is called on startup with
+// persistence of every tenant. Commands issued by sagas should be idempotent,
+// as they may have been served before the restart.
+func (m *Manager) Resume(persistence server.Persistence) error {
+	for _, def := range m.definitions {
+		events, err := persistence.FetchEvents(server.EventFilter{EntityType: def.SagaType})
+		if err != nil {
+			return err
+		}
+		last := map[es.EntityId]es.Event{}
+		for _, event := range events {
+			last[event.EntityId] = event
+		}
+		for sagaId, event := range last {
+			entity, err := persistence.FetchEntity(def.SagaType, sagaId)
+			if err != nil {
+				return err
+			}
+			saga, ok := entity.(Saga)
+			if !ok {
+				continue
+			}
+			cmd := &sagaCommand{def: def, sagaId: sagaId}
+			cmd.resume(saga.SagaState())
+			if len(cmd.issued) == 0 {
+				continue
+			}
+			ctx := server.WithCorrelation(server.WithTenant(context.Background(), event.Metadata.TenantId), event.CorrelationId, es.EntityId(event.EventId))
+			m.wg.Add(1)
+			go m.serveIssued(ctx, "", cmd, cmd.issued)
+		}
+	}
+	return nil
+}
+
+// React starts saga steps for committed events and serves commands issued by saga steps.
+func (m *Manager) React(result *server.ServiceResult) {
+	if internal, ok := result.Command.(internalCommand); ok {
+		if len(internal.issuedCommands()) > 0 {
+			ctx := server.WithCorrelation(server.WithTenant(context.Background(), result.TenantId), result.CorrelationId, result.CausedBy())
+			m.wg.Add(1)
+			go m.serveIssued(ctx, result.ConnectionId, internal.base(), internal.issuedCommands())
+		}
+		return
+	}
+
+	type step struct {
+		ctx     context.Context
+		command server.Command
+	}
+	var steps []step
+	for _, event := range result.Events {
+		for _, def := range m.definitions {
+			if event.EntityType == def.SagaType {
+				continue
+			}
+			if sagaId, ok := def.Correlate(event); ok {
+				steps = append(steps, step{
+					ctx:     server.WithPrincipal(server.WithCorrelation(server.WithTenant(context.Background(), result.TenantId), event.CorrelationId, es.EntityId(event.EventId)), result.Principal),
+					command: &stepCommand{sagaCommand: sagaCommand{def: def, sagaId: sagaId}, event: event},
+				})
+			}
+		}
+	}
+	if len(steps) == 0 {
+		return
+	}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		for _, step := range steps {
+			<-m.server.ServeCommand(step.ctx, result.ConnectionId, step.command)
+		}
+	}()
+}
+
+// serveIssued serves issued commands in order, recording outcome of each.
+func (m *Manager) serveIssued(ctx context.Context, connId es.EntityId, saga *sagaCommand, cmds []issuedCommand) {
+	defer m.wg.Done()
+	ctx = server.WithPrincipal(ctx, saga.principal)
+	for _, cmd := range cmds {
+		result := (<-m.server.ServeContext(ctx, connId, cmd.CommandType, cmd.Info)).(*server.ServiceResult)
+		outcomeCtx := server.WithCorrelation(ctx, result.CorrelationId, result.CausedBy())
+		<-m.server.ServeCommand(outcomeCtx, connId, &outcomeCommand{
+			sagaCommand: sagaCommand{def: saga.def, sagaId: saga.sagaId},
+			step:        cmd.step,
+			failed:      result.Failure != nil,
+		})
+	}
+}
+
+type issuedCommand struct {
+	Command
+	step string
+}
+
+type internalCommand interface {
+	base() *sagaCommand
+	issuedCommands() []issuedCommand
+}
+
+type sagaCommand struct {
+	def       *Definition
+	sagaId    es.EntityId
+	principal server.Principal
+	issued    []issuedCommand
+}
+
+func (c *sagaCommand) base() *sagaCommand {
+	return c
+}
+
+func (c *sagaCommand) issuedCommands() []issuedCommand {
+	return c.issued
+}
+
+func (c *sagaCommand) Validate(helper server.CommandHelper) error {
+	return nil
+}
+
+func (c *sagaCommand) Authorize(helper server.CommandHelper) error {
+	return nil
+}
+
+func (c *sagaCommand) Targets() []server.EntityKey {
+	return []server.EntityKey{{EntityType: c.def.SagaType, EntityId: c.sagaId}}
+}
+
+func (c *sagaCommand) fetch(helper server.CommandHelper, create bool) (Saga, error) {
+	entity, err := helper.FetchEntity(c.def.SagaType, c.sagaId)
+	if err != nil {
+		return nil, err
+	}
+	if entity != nil {
+		c.principal = entity.(Saga).SagaState().Principal
+		return entity.(Saga), nil
+	}
+	if !create {
+		return nil, nil
+	}
+	saga := c.def.New(c.sagaId)
+	*saga.SagaState() = State{
+		SagaId:        c.sagaId,
+		SagaType:      c.def.SagaType,
+		CorrelationId: server.CorrelationId(helper.Context()),
+		Principal:     helper.Principal(),
+		Status:        Active,
+		Steps:         es.Info{},
+	}
+	c.principal = saga.SagaState().Principal
+	helper.CreateEntity(saga)
+	return saga, nil
+}
+
+func (c *sagaCommand) issue(step string, command Command) {
+	c.issued = append(c.issued, issuedCommand{Command: command, step: step})
+}
+
+// compensate issues compensations of succeeded steps in reverse order.
+func (c *sagaCommand) compensate(state *State) {
+	state.Status = Compensating
+	keys := make([]string, 0, len(state.Steps))
+	for key := range state.Steps {
+		keys = append(keys, key)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	for _, key := range keys {
+		c.compensateStep(state, key)
+	}
+	c.checkCompensated(state)
+}
+
+func (c *sagaCommand) compensateStep(state *State, key string) {
+	step := state.Steps[key].(es.Info)
+	if step["status"] != stepSucceeded {
+		return
+	}
+	if _, ok := step["compensation_type"].(string); !ok {
+		step["status"] = stepCompensated
+		return
+	}
+	step["status"] = stepCompensating
+	c.issueCompensation(key, step)
+}
+
+func (c *sagaCommand) issueCompensation(key string, step es.Info) {
+	compensationType, _ := step["compensation_type"].(string)
+	compensationInfo, _ := step["compensation_info"].(es.Info)
+	c.issue(key, Command{CommandType: es.CommandType(compensationType), Info: compensationInfo})
+}
+
+// resume issues commands of steps still waiting for their outcome in the order they were issued.
+func (c *sagaCommand) resume(state *State) {
+	c.principal = state.Principal
+	keys := make([]string, 0, len(state.Steps))
+	for key := range state.Steps {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		step := state.Steps[key].(es.Info)
+		switch step["status"] {
+		case stepIssued:
+			commandType, _ := step["command_type"].(string)
+			commandInfo, _ := step["command_info"].(es.Info)
+			c.issue(key, Command{CommandType: es.CommandType(commandType), Info: commandInfo})
+		case stepCompensating:
+			c.issueCompensation(key, step)
+		}
+	}
+}
+
+func (c *sagaCommand) checkCompensated(state *State) {
+	for _, step := range state.Steps {
+		status := step.(es.Info)["status"]
+		if status == stepCompensating || status == stepIssued {
+			return
+		}
+	}
+	state.Status = Compensated
+}
+
+type stepCommand struct {
+	sagaCommand
+	event es.Event
+}
+
+func (c *stepCommand) CommandType() es.CommandType {
+	return "saga_step"
+}
+
+func (c *stepCommand) Handle(helper server.CommandHelper) error {
+	starts := c.def.Starts != nil && c.def.Starts(c.event)
+	saga, err := c.fetch(helper, starts)
+	if err != nil || saga == nil {
+		return err
+	}
+	if saga.SagaState().Status != Active {
+		return nil
+	}
+	return saga.Handle(&sagaContext{helper: helper, command: &c.sagaCommand, state: saga.SagaState()}, c.event)
+}
+
+type timeoutCommand struct {
+	sagaCommand
+	name string
+}
+
+func (c *timeoutCommand) CommandType() es.CommandType {
+	return TimeoutCommand
+}
+
+func (c *timeoutCommand) Handle(helper server.CommandHelper) error {
+	saga, err := c.fetch(helper, false)
+	if err != nil || saga == nil {
+		return err
+	}
+	if saga.SagaState().Status != Active {
+		return nil
+	}
+	return saga.Timeout(&sagaContext{helper: helper, command: &c.sagaCommand, state: saga.SagaState()}, c.name)
+}
+
+// outcomeCommand records result of a command issued by the saga.
+type outcomeCommand struct {
+	sagaCommand
+	step   string
+	failed bool
+}
+
+func (c *outcomeCommand) CommandType() es.CommandType {
+	return "saga_outcome"
+}
+
+func (c *outcomeCommand) Handle(helper server.CommandHelper) error {
+	saga, err := c.fetch(helper, false)
+	if err != nil {
+		return err
+	}
+	if saga == nil {
+		return errors.NewError(errors.Failure, SagaNotFound, "Saga not found.", es.Info{"saga_id": c.sagaId})
+	}
+	state := saga.SagaState()
+	step, ok := state.Steps[c.step].(es.Info)
+	if !ok {
+		return nil
+	}
+
+	switch step["status"] {
+	case stepIssued:
+		if c.failed {
+			step["status"] = stepFailed
+			if state.Status == Active || state.Status == Completed {
+				c.compensate(state)
+				return nil
+			}
+		} else {
+			step["status"] = stepSucceeded
+			if state.Status == Compensating {
+				c.compensateStep(state, c.step)
+			}
+		}
+	case stepCompensating:
+		if c.failed {
+			step["status"] = stepCompensationFailed
+		} else {
+			step["status"] = stepCompensated
+		}
+	}
+	if state.Status == Compensating {
+		c.checkCompensated(state)
+	}
+	return nil
+}
+
+type sagaContext struct {
+	helper  server.CommandHelper
+	command *sagaCommand
+	state   *State
+}
+
+func (ctx *sagaContext) Now() time.Time {
+	return ctx.helper.Now()
+}
+
+func (ctx *sagaContext) CorrelationId() es.EntityId {
+	return ctx.state.CorrelationId
+}
+
+func (ctx *sagaContext) Issue(command Command) {
+	ctx.addStep(command, nil)
+}
+
+func (ctx *sagaContext) IssueCompensable(command, compensation Command) {
+	ctx.addStep(command, &compensation)
+}
+
+func (ctx *sagaContext) addStep(command Command, compensation *Command) {
+	key := fmt.Sprintf("%06d", ctx.state.StepCount)
+	ctx.state.StepCount++
+	step := es.Info{
+		"command_type": string(command.CommandType),
+		"status":       stepIssued,
+	}
+	if command.Info != nil {
+		step["command_info"] = command.Info
+	}
+	if compensation != nil {
+		step["compensation_type"] = string(compensation.CommandType)
+		if compensation.Info != nil {
+			step["compensation_info"] = compensation.Info
+		}
+	}
+	if ctx.state.Steps == nil {
+		ctx.state.Steps = es.Info{}
+	}
+	ctx.state.Steps[key] = step
+	ctx.command.issue(key, command)
+}
+
+func (ctx *sagaContext) ScheduleTimeout(name string, due time.Time) {
+	ctx.helper.ScheduleCommand(TimeoutCommand, es.Info{
+		"saga_type": string(ctx.state.SagaType),
+		"saga_id":   string(ctx.state.SagaId),
+		"name":      name,
+	}, due)
+}
+
+func (ctx *sagaContext) Complete() {
+	ctx.state.Status = Completed
+}
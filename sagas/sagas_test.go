@@ -0,0 +1,194 @@
+package sagas
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/persistence/in_memory"
+	"github.com/andrew-suprun/legion/server"
+	"github.com/andrew-suprun/legion/tests"
+)
+
+type order struct {
+	OrderId es.EntityId `json:"order_id"`
+}
+
+func (o *order) EntityId() es.EntityId     { return o.OrderId }
+func (o *order) EntityType() es.EntityType { return "order" }
+
+type orderSaga struct {
+	State
+	OrderId string `json:"order_id"`
+}
+
+func (s *orderSaga) Handle(ctx Context, event es.Event) error {
+	s.OrderId = string(event.EntityId)
+	ctx.IssueCompensable(
+		Command{CommandType: "reserve_stock", Info: es.Info{"order_id": s.OrderId}},
+		Command{CommandType: "release_stock", Info: es.Info{"order_id": s.OrderId}})
+	ctx.Issue(Command{CommandType: "charge_payment", Info: es.Info{"order_id": s.OrderId}})
+	ctx.Complete()
+	return nil
+}
+
+func (s *orderSaga) Timeout(ctx Context, name string) error {
+	return nil
+}
+
+var orderSagaDefinition = Definition{
+	SagaType: "order_saga",
+	New:      func(id es.EntityId) Saga { return &orderSaga{} },
+	Correlate: func(event es.Event) (es.EntityId, bool) {
+		return event.EntityId, event.EntityType == "order"
+	},
+	Starts: func(event es.Event) bool { return event.CommandType == "place_order" },
+}
+
+type testCommand struct {
+	cmdType es.CommandType
+	served  *served
+}
+
+type served struct {
+	sync.Mutex
+	commands   []es.CommandType
+	principals []es.EntityId
+}
+
+func (c testCommand) CommandType() es.CommandType                 { return c.cmdType }
+func (c testCommand) Validate(helper server.CommandHelper) error  { return nil }
+func (c testCommand) Authorize(helper server.CommandHelper) error { return nil }
+func (c testCommand) Handle(helper server.CommandHelper) error {
+	c.served.Lock()
+	c.served.commands = append(c.served.commands, c.cmdType)
+	c.served.principals = append(c.served.principals, helper.Principal().UserId)
+	c.served.Unlock()
+	switch c.cmdType {
+	case "place_order":
+		helper.CreateEntity(&order{OrderId: "order-1"})
+	case "charge_payment":
+		return errors.NewError(errors.Failure, "payment_declined", "Payment declined.")
+	}
+	return nil
+}
+
+func TestCompensation(t *testing.T) {
+	s := &served{}
+	m := NewManager(orderSagaDefinition)
+	commandFactory := func(cmdType es.CommandType, info es.Info) (server.Command, error) {
+		return testCommand{cmdType: cmdType, served: s}, nil
+	}
+	entityFactory := func(et es.EntityType, id es.EntityId) (es.Entity, error) {
+		return &order{}, nil
+	}
+	test := tests.NewTest(t, m.CommandFactory(commandFactory), m.EntityFactory(entityFactory), server.WithReactor(m.React))
+	m.Attach(test.Server)
+	defer test.Shutdown()
+
+	ctx := server.WithPrincipal(server.WithTenant(context.Background(), test.TenantId), server.Principal{UserId: "alice", TenantId: test.TenantId})
+	placed := (<-test.ServeContext(ctx, "conn", "place_order", nil)).(*server.ServiceResult)
+	if placed.Failure != nil {
+		t.Fatalf("Unexpected failure %v", placed.Failure)
+	}
+	orderEvents := placed.Events
+	m.Wait()
+
+	expected := []es.CommandType{"place_order", "reserve_stock", "charge_payment", "release_stock"}
+	if len(s.commands) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, s.commands)
+	}
+	for i := range expected {
+		if s.commands[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, s.commands)
+		}
+		if s.principals[i] != "alice" {
+			t.Fatalf("Expected %s to be served for the principal that started the saga, got %q", s.commands[i], s.principals[i])
+		}
+	}
+
+	entity, _ := test.FetchEntity("order_saga", "order-1")
+	if status := entity.(*orderSaga).Status; status != Compensated {
+		t.Fatalf("Expected saga to be compensated, got %q", status)
+	}
+	if correlationId := entity.(*orderSaga).CorrelationId; correlationId != orderEvents[0].CorrelationId {
+		t.Fatalf("Expected saga to be correlated with the order, got %q", correlationId)
+	}
+
+	chain, _ := test.FetchCausalChain(orderEvents[0].CorrelationId)
+	sagaEvents := 0
+	for _, event := range chain {
+		if event.EntityType == "order_saga" {
+			sagaEvents++
+		}
+	}
+	if len(chain) < 2 || chain[0].EventId != orderEvents[0].EventId || sagaEvents != len(chain)-1 {
+		t.Fatalf("Expected saga events to be correlated with the order, got %v", chain)
+	}
+	eventIds := map[es.EntityId]bool{}
+	for _, event := range chain {
+		if event.CausationId != "" && !eventIds[event.CausationId] {
+			t.Fatalf("Expected causation of %s to be an earlier event of the chain, got %v", event.EventId, chain)
+		}
+		eventIds[es.EntityId(event.EventId)] = true
+	}
+}
+
+func TestResume(t *testing.T) {
+	s := &served{}
+	commandFactory := func(cmdType es.CommandType, info es.Info) (server.Command, error) {
+		return testCommand{cmdType: cmdType, served: s}, nil
+	}
+	entityFactory := func(et es.EntityType, id es.EntityId) (es.Entity, error) {
+		return &order{}, nil
+	}
+
+	// the saga issues its steps, but the server stops before they are served
+	stopped := NewManager(orderSagaDefinition)
+	p := in_memory.NewPersistence(stopped.EntityFactory(entityFactory))
+	before := server.New(clock{}, p, stopped.CommandFactory(commandFactory))
+	result := (<-before.Serve("", "conn", "place_order", nil)).(*server.ServiceResult)
+	step := &stepCommand{sagaCommand: sagaCommand{def: stopped.definitions["order_saga"], sagaId: "order-1"}, event: result.Events[0]}
+	ctx := server.WithPrincipal(context.Background(), server.Principal{UserId: "bob"})
+	<-before.ServeCommand(server.WithCorrelation(ctx, result.CorrelationId, es.EntityId(result.Events[0].EventId)), "conn", step)
+	before.Shutdown()
+
+	m := NewManager(orderSagaDefinition)
+	after := server.New(clock{}, p, m.CommandFactory(commandFactory), server.WithReactor(m.React))
+	defer after.Shutdown()
+	m.Attach(after)
+	if err := m.Resume(p); err != nil {
+		t.Fatal(err)
+	}
+	m.Wait()
+
+	expected := []es.CommandType{"place_order", "reserve_stock", "charge_payment", "release_stock"}
+	if len(s.commands) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, s.commands)
+	}
+	entity, _ := p.FetchEntity("order_saga", "order-1")
+	if status := entity.(*orderSaga).Status; status != Compensated {
+		t.Fatalf("Expected resumed saga to be compensated, got %q", status)
+	}
+	for i, principal := range s.principals[1:] {
+		if principal != "bob" {
+			t.Fatalf("Expected resumed %s to be served for the principal that started the saga, got %q", s.commands[i+1], principal)
+		}
+	}
+	if err := m.Resume(p); err != nil {
+		t.Fatal(err)
+	}
+	m.Wait()
+	if len(s.commands) != len(expected) {
+		t.Fatalf("Expected completed saga not to be resumed, got %v", s.commands)
+	}
+}
+
+type clock struct{}
+
+func (clock) Now() time.Time {
+	return time.Now()
+}
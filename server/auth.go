@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+)
+
+// Principal is the authenticated user on whose behalf commands are served.
+type Principal struct {
+	UserId   es.EntityId `json:"user_id,omitempty"`
+	TenantId es.TenantId `json:"tenant_id,omitempty"`
+	Roles    []string    `json:"roles,omitempty"`
+	Scopes   []string    `json:"scopes,omitempty"`
+}
+
+// Authenticated returns false for the zero Principal of unauthenticated connections.
+func (p Principal) Authenticated() bool {
+	return p.UserId != ""
+}
+
+func (p Principal) HasRole(role string) bool {
+	return contains(p.Roles, role)
+}
+
+func (p Principal) HasScope(scope string) bool {
+	return contains(p.Scopes, scope)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Credentials are presented by a connection, e.g. a bearer token.
+type Credentials struct {
+	Token string `json:"token,omitempty"`
+}
+
+// Authenticator resolves credentials to a principal;
+// it returns errors.Error with errors.Unauthorized code if the credentials are not valid.
+type Authenticator interface {
+	Authenticate(credentials Credentials) (Principal, error)
+}
+
+func WithAuthenticator(authenticator Authenticator) Option {
+	return func(s *Server) {
+		s.authenticator = authenticator
+	}
+}
+
+// Authenticate resolves credentials with the authenticator of the server.
+func (s *Server) Authenticate(credentials Credentials) (Principal, error) {
+	if s.authenticator == nil {
+		return Principal{}, errors.NewError(errors.Failure, errors.Unauthorized, "Server is not configured to authenticate connections.")
+	}
+	return s.authenticator.Authenticate(credentials)
+}
+
+// Connect authenticates the connection; commands served for the connection
+// are authorized against the resolved principal until Disconnect.
+func (s *Server) Connect(connId es.EntityId, credentials Credentials) (Principal, error) {
+	principal, err := s.Authenticate(credentials)
+	if err != nil {
+		return Principal{}, err
+	}
+	s.connectionsLock.Lock()
+	s.connections[connId] = principal
+	s.connectionsLock.Unlock()
+	return principal, nil
+}
+
+func (s *Server) Disconnect(connId es.EntityId) {
+	s.connectionsLock.Lock()
+	delete(s.connections, connId)
+	s.connectionsLock.Unlock()
+}
+
+type principalKey struct{}
+
+// WithPrincipal serves commands with the context on behalf of the principal regardless of their connection.
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// principal resolves the principal of the command and checks that it belongs to the tenant of the command.
+// Commands served without a tenant are served for the tenant of the principal.
+func (s *Server) principal(ctx context.Context, result *ServiceResult) (Principal, error) {
+	principal, ok := PrincipalFrom(ctx)
+	if !ok {
+		s.connectionsLock.Lock()
+		principal = s.connections[result.ConnectionId]
+		s.connectionsLock.Unlock()
+	}
+	if result.TenantId == "" {
+		result.TenantId = principal.TenantId
+	}
+	if principal.TenantId != "" && principal.TenantId != result.TenantId {
+		return Principal{}, errors.NewError(errors.Failure, errors.Unauthorized, "Principal does not belong to the tenant.", es.Info{
+			"user_id":   principal.UserId,
+			"tenant_id": result.TenantId,
+		})
+	}
+	return principal, nil
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"context"
+
+	"github.com/andrew-suprun/legion/es"
+)
+
+type correlationKey struct{}
+
+type correlation struct {
+	correlationId es.EntityId
+	causationId   es.EntityId
+}
+
+// WithCorrelation marks commands served with the context as caused by the event causationId
+// within the chain of commands identified by correlationId.
+func WithCorrelation(ctx context.Context, correlationId, causationId es.EntityId) context.Context {
+	return context.WithValue(ctx, correlationKey{}, correlation{correlationId: correlationId, causationId: causationId})
+}
+
+func CorrelationId(ctx context.Context) es.EntityId {
+	c, _ := ctx.Value(correlationKey{}).(correlation)
+	return c.correlationId
+}
+
+func CausationId(ctx context.Context) es.EntityId {
+	c, _ := ctx.Value(correlationKey{}).(correlation)
+	return c.causationId
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/andrew-suprun/legion/actors"
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+)
+
+type EntityKey struct {
+	EntityType es.EntityType `json:"entity_type"`
+	EntityId   es.EntityId   `json:"entity_id"`
+}
+
+// TargetedCommand declares entities the command is going to modify.
+// With entity actors enabled such commands are executed sequentially per entity.
+type TargetedCommand interface {
+	Command
+	Targets() []EntityKey
+}
+
+// tenantKey keeps actors and cached entities of different tenants apart.
+type tenantKey struct {
+	TenantId es.TenantId
+	EntityKey
+}
+
+type entityActors struct {
+	lock        sync.Mutex
+	idleTimeout time.Duration
+	actors      map[tenantKey]*entityActor
+	stopChan    chan bool
+	stopped     bool
+	holds       sync.WaitGroup // commands holding or waiting for actors
+}
+
+type entityActor struct {
+	actor    actors.Actor
+	busy     int
+	lastUsed time.Time
+	entity   es.Entity
+	version  int // incremented when other commands write the entity
+}
+
+func newEntityActors(idleTimeout time.Duration) *entityActors {
+	ea := &entityActors{
+		idleTimeout: idleTimeout,
+		actors:      map[tenantKey]*entityActor{},
+		stopChan:    make(chan bool),
+	}
+	go ea.passivate()
+	return ea
+}
+
+// handleHold keeps the actor busy until the command holding the entity releases it.
+func handleHold(msg interface{}) {
+	request := msg.(actors.Request)
+	request.Reply(true)
+	<-request.Message.(chan bool)
+}
+
+// acquire blocks until all the keys are held by the caller.
+// Keys are acquired in sorted order so that commands with overlapping targets cannot deadlock.
+func (ea *entityActors) acquire(ctx context.Context, tenantId es.TenantId, keys []EntityKey) (held map[tenantKey]*entityActor, release func(), err error) {
+	ea.lock.Lock()
+	if ea.stopped {
+		ea.lock.Unlock()
+		return nil, nil, errors.NewError(errors.Failure, ServerError, "Server is shutting down.")
+	}
+	ea.holds.Add(1)
+	ea.lock.Unlock()
+
+	keys = sortedKeys(keys)
+	held = map[tenantKey]*entityActor{}
+	releases := make([]chan bool, 0, len(keys))
+	release = func() {
+		ea.lock.Lock()
+		for _, a := range held {
+			a.busy--
+			a.lastUsed = time.Now()
+		}
+		ea.lock.Unlock()
+		for _, r := range releases {
+			close(r)
+		}
+		ea.holds.Done()
+	}
+
+	for _, entityKey := range keys {
+		key := tenantKey{TenantId: tenantId, EntityKey: entityKey}
+		ea.lock.Lock()
+		a, ok := ea.actors[key]
+		if !ok {
+			a = &entityActor{actor: actors.NewActor(handleHold)}
+			ea.actors[key] = a
+		}
+		a.busy++
+		ea.lock.Unlock()
+
+		held[key] = a
+		r := make(chan bool)
+		releases = append(releases, r)
+		if _, err = a.actor.Ask(ctx, r).Result(); err != nil {
+			release()
+			return nil, nil, err
+		}
+	}
+
+	return held, release, nil
+}
+
+// take moves cached entities out of the actors while the command is running,
+// so that entities modified by failed or panicked commands are never cached.
+func (ea *entityActors) take(held map[tenantKey]*entityActor) (map[EntityKey]es.Entity, map[tenantKey]int) {
+	ea.lock.Lock()
+	defer ea.lock.Unlock()
+	cached := map[EntityKey]es.Entity{}
+	versions := map[tenantKey]int{}
+	for key, a := range held {
+		if a.entity != nil {
+			cached[key.EntityKey] = a.entity
+			a.entity = nil
+		}
+		versions[key] = a.version
+	}
+	return cached, versions
+}
+
+// cache keeps entities of the succeeded command in its actors unless other commands
+// wrote them while the command was running.
+func (ea *entityActors) cache(held map[tenantKey]*entityActor, versions map[tenantKey]int, entities map[es.EntityId]es.Entity) {
+	ea.lock.Lock()
+	defer ea.lock.Unlock()
+	for key, a := range held {
+		if entity, ok := entities[key.EntityId]; ok && entity.EntityType() == key.EntityType && a.version == versions[key] {
+			a.entity = entity
+		}
+	}
+}
+
+// invalidate drops cached copies of entities written by the events, except for entities held by the writer.
+func (ea *entityActors) invalidate(tenantId es.TenantId, events es.Events, held map[tenantKey]*entityActor) {
+	ea.lock.Lock()
+	defer ea.lock.Unlock()
+	for _, event := range events {
+		key := tenantKey{TenantId: tenantId, EntityKey: EntityKey{EntityType: event.EntityType, EntityId: event.EntityId}}
+		if _, ok := held[key]; ok {
+			continue
+		}
+		if a, ok := ea.actors[key]; ok {
+			a.entity = nil
+			a.version++
+		}
+	}
+}
+
+func (ea *entityActors) passivate() {
+	ticker := time.NewTicker(ea.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ea.shutdownIdle(time.Now().Add(-ea.idleTimeout))
+		case <-ea.stopChan:
+			return
+		}
+	}
+}
+
+func (ea *entityActors) shutdownIdle(cutoff time.Time) {
+	var idle []*entityActor
+	ea.lock.Lock()
+	for key, a := range ea.actors {
+		if a.busy == 0 && !a.lastUsed.After(cutoff) {
+			delete(ea.actors, key)
+			idle = append(idle, a)
+		}
+	}
+	ea.lock.Unlock()
+
+	for _, a := range idle {
+		a.entity = nil
+		a.actor.Shutdown()
+	}
+}
+
+// shutdown rejects new commands, waits for commands holding or waiting for actors
+// to release them and passivates all the actors.
+func (ea *entityActors) shutdown() {
+	ea.lock.Lock()
+	ea.stopped = true
+	ea.lock.Unlock()
+	close(ea.stopChan)
+	ea.holds.Wait()
+	ea.shutdownIdle(time.Now())
+}
+
+func sortedKeys(keys []EntityKey) []EntityKey {
+	unique := map[EntityKey]bool{}
+	result := make([]EntityKey, 0, len(keys))
+	for _, key := range keys {
+		if !unique[key] {
+			unique[key] = true
+			result = append(result, key)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].EntityType != result[j].EntityType {
+			return result[i].EntityType < result[j].EntityType
+		}
+		return result[i].EntityId < result[j].EntityId
+	})
+	return result
+}
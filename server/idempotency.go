@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/tasks"
+)
+
+const (
+	IdempotencyKeyReused        errors.ErrorCode = "idempotency_key_reused"
+	IdempotentCommandInProgress errors.ErrorCode = "idempotent_command_in_progress"
+)
+
+// IdempotencyLease is how long a pending reservation holds its key. Reservations left pending
+// for longer, e.g. by a crash between reserving the key and storing the result, are taken over by retries,
+// so commands served with idempotency keys have to complete within the lease.
+const IdempotencyLease = time.Minute
+
+// IdempotentResult is the stored outcome of a successful command served with an idempotency key.
+// Pending results reserve the key while the command is executed; their CompletedAt is the time of the reservation.
+type IdempotentResult struct {
+	TenantId      es.TenantId    `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+	Key           string         `json:"key" bson:"key"`
+	CommandType   es.CommandType `json:"command_type" bson:"command_type"`
+	CommandId     es.EntityId    `json:"command_id" bson:"command_id"`
+	CorrelationId es.EntityId    `json:"correlation_id,omitempty" bson:"correlation_id,omitempty"`
+	Events        es.Events      `json:"events,omitempty" bson:"events,omitempty"`
+	Messages      es.Messages    `json:"messages,omitempty" bson:"messages,omitempty"`
+	Diagnostics   errors.Errors  `json:"diagnostics,omitempty" bson:"diagnostics,omitempty"`
+	CompletedAt   time.Time      `json:"completed_at" bson:"completed_at"`
+	Pending       bool           `json:"pending,omitempty" bson:"pending,omitempty"`
+}
+
+// IdempotencyStore keeps results of commands served with idempotency keys, per tenant.
+// ReserveIdempotencyKey atomically stores the pending reservation unless a result with the same key
+// completed at or after expiredBefore or a pending one reserved at or after leaseExpiredBefore exists,
+// and reports whether the key was reserved.
+type IdempotencyStore interface {
+	ReserveIdempotencyKey(reservation IdempotentResult, expiredBefore, leaseExpiredBefore time.Time) (bool, error)
+	PersistIdempotentResult(result IdempotentResult) error
+	FetchIdempotentResult(tenantId es.TenantId, key string) (*IdempotentResult, error)
+	DeleteIdempotentResult(tenantId es.TenantId, key string) error
+	DeleteIdempotentResults(completedBefore time.Time) error
+}
+
+type idempotency struct {
+	store     IdempotencyStore
+	retention time.Duration
+	lock      sync.Mutex
+	inFlight  map[idempotencyKey]chan bool
+}
+
+type idempotencyKey struct {
+	tenantId es.TenantId
+	key      string
+}
+
+// WithIdempotency makes commands served with the same idempotency key execute at most once
+// while their results are retained. Only successful results are retained, so failed commands can be retried.
+func WithIdempotency(store IdempotencyStore, retention time.Duration) Option {
+	return func(s *Server) {
+		s.idempotency = &idempotency{
+			store:     store,
+			retention: retention,
+			inFlight:  map[idempotencyKey]chan bool{},
+		}
+	}
+}
+
+type idempotencyCtxKey struct{}
+
+// WithIdempotencyKey marks commands served with the context as retries of each other.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyCtxKey{}, key)
+}
+
+func IdempotencyKey(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyCtxKey{}).(string)
+	return key
+}
+
+// serveOnce returns the stored result of the command with the same key or serves the command.
+// Concurrent commands with the same key wait for the first one to complete. The key is reserved
+// before the command is executed, so a command is not executed again once it could have committed:
+// if the result cannot be stored, retries fail as in progress until the lease of the reservation ends.
+func (s *Server) serveOnce(ctx context.Context, cmd Command, result *ServiceResult) chan interface{} {
+	key := idempotencyKey{tenantId: result.TenantId, key: IdempotencyKey(ctx)}
+	return tasks.Start(func() interface{} {
+		for {
+			s.idempotency.lock.Lock()
+			if done, ok := s.idempotency.inFlight[key]; ok {
+				s.idempotency.lock.Unlock()
+				<-done
+				continue
+			}
+			done := make(chan bool)
+			s.idempotency.inFlight[key] = done
+			s.idempotency.lock.Unlock()
+
+			stored, err := s.idempotency.fetch(key, s.timeService.Now())
+			if err == nil && stored == nil {
+				var reserved bool
+				reserved, err = s.idempotency.reserve(key, cmd, s.timeService.Now())
+				if err == nil && !reserved {
+					s.idempotency.complete(key, done)
+					continue
+				}
+			}
+			if err != nil || stored != nil {
+				s.idempotency.complete(key, done)
+				if err != nil {
+					result.Failure = err
+					return result
+				}
+				if stored.Pending {
+					result.Failure = errors.NewError(errors.Failure, IdempotentCommandInProgress, "Command with the idempotency key is in progress.", es.Info{
+						"idempotency_key": stored.Key,
+					})
+					return result
+				}
+				return s.idempotency.replay(stored, cmd, result)
+			}
+
+			served := (<-s.execute(ctx, cmd, result)).(*ServiceResult)
+			if served.Failure == nil {
+				err = s.idempotency.persist(key, served, s.timeService.Now())
+			} else {
+				err = s.idempotency.release(key)
+			}
+			if err != nil {
+				served.Diagnostics = append(served.Diagnostics, err.(errors.Error))
+			}
+			s.idempotency.complete(key, done)
+			return served
+		}
+	})
+}
+
+func (i *idempotency) complete(key idempotencyKey, done chan bool) {
+	i.lock.Lock()
+	delete(i.inFlight, key)
+	i.lock.Unlock()
+	close(done)
+}
+
+func (i *idempotency) reserve(key idempotencyKey, cmd Command, now time.Time) (bool, error) {
+	reserved, err := i.store.ReserveIdempotencyKey(IdempotentResult{
+		TenantId:    key.tenantId,
+		Key:         key.key,
+		CommandType: cmd.CommandType(),
+		CompletedAt: now,
+		Pending:     true,
+	}, now.Add(-i.retention), now.Add(-IdempotencyLease))
+	if err != nil {
+		return false, errors.Wrap(err, errors.Failure, DatabaseError, "Failed to reserve idempotency key.")
+	}
+	return reserved, nil
+}
+
+// release drops the reservation of the failed command, so that it can be retried.
+func (i *idempotency) release(key idempotencyKey) error {
+	if err := i.store.DeleteIdempotentResult(key.tenantId, key.key); err != nil {
+		return errors.Wrap(err, errors.Diagnostics, DatabaseError, "Failed to release idempotency key.")
+	}
+	return nil
+}
+
+func (i *idempotency) fetch(key idempotencyKey, now time.Time) (*IdempotentResult, error) {
+	stored, err := i.store.FetchIdempotentResult(key.tenantId, key.key)
+	if err != nil {
+		return nil, errors.Wrap(err, errors.Failure, DatabaseError, "Failed to fetch idempotent result.")
+	}
+	if stored == nil || stored.CompletedAt.Before(now.Add(-i.retention)) {
+		return nil, nil
+	}
+	if stored.Pending && stored.CompletedAt.Before(now.Add(-IdempotencyLease)) {
+		return nil, nil
+	}
+	return stored, nil
+}
+
+// persist stores the result of the committed command; failing to store it is reported as a diagnostic.
+func (i *idempotency) persist(key idempotencyKey, result *ServiceResult, now time.Time) error {
+	err := i.store.PersistIdempotentResult(IdempotentResult{
+		TenantId:      key.tenantId,
+		Key:           key.key,
+		CommandType:   result.Command.CommandType(),
+		CommandId:     result.CommandId,
+		CorrelationId: result.CorrelationId,
+		Events:        result.Events,
+		Messages:      result.Messages,
+		Diagnostics:   result.Diagnostics,
+		CompletedAt:   now,
+	})
+	if err == nil {
+		err = i.store.DeleteIdempotentResults(now.Add(-i.retention))
+	}
+	if err != nil {
+		return errors.Wrap(err, errors.Diagnostics, DatabaseError, "Failed to store idempotent result.")
+	}
+	return nil
+}
+
+// replay returns the stored result instead of the result of the new command;
+// stored messages are not dispatched again.
+func (i *idempotency) replay(stored *IdempotentResult, cmd Command, result *ServiceResult) *ServiceResult {
+	if stored.CommandType != cmd.CommandType() {
+		result.Failure = errors.NewError(errors.Failure, IdempotencyKeyReused, "Idempotency key was used for another command.", es.Info{
+			"idempotency_key": stored.Key,
+			"command_type":    stored.CommandType,
+		})
+		return result
+	}
+	result.CommandId = stored.CommandId
+	result.CorrelationId = stored.CorrelationId
+	result.Events = stored.Events
+	result.Messages = stored.Messages
+	result.Diagnostics = stored.Diagnostics
+	result.Duplicate = true
+	return result
+}
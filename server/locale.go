@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/locale"
+)
+
+// MessageText is the info field of replies translated to the locale of the caller;
+// the rest of info is used as params of the translation.
+const MessageText = "text"
+
+type localeKey struct{}
+
+// WithLocale sets preferred locale of the caller. Descriptions of diagnostics and failures
+// and texts of replies of commands served with the context are translated with locale.Translate.
+func WithLocale(ctx context.Context, preferred string) context.Context {
+	return context.WithValue(ctx, localeKey{}, locale.Normalize(preferred))
+}
+
+// Locale returns preferred locale of the caller; commands served without locale are not translated.
+func Locale(ctx context.Context) string {
+	preferred, _ := ctx.Value(localeKey{}).(string)
+	return preferred
+}
+
+func translateError(preferred string, err error) error {
+	if e, ok := err.(errors.Error); ok && preferred != "" {
+		e.Description = locale.Translate(preferred, e.Description, e.Info)
+		return e
+	}
+	return err
+}
+
+func translateErrors(preferred string, errs errors.Errors) errors.Errors {
+	for i := range errs {
+		errs[i] = translateError(preferred, errs[i]).(errors.Error)
+	}
+	return errs
+}
+
+func translateReply(preferred string, info es.Info) es.Info {
+	if text, ok := info[MessageText].(string); ok && preferred != "" {
+		info[MessageText] = locale.Translate(preferred, text, info)
+	}
+	return info
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/andrew-suprun/legion/es"
+)
+
+type metadataKey struct{}
+
+// WithMetadata sets tenant and source recorded in events of commands served with the context;
+// the recorded principal is always the authenticated principal of the command.
+func WithMetadata(ctx context.Context, metadata es.Metadata) context.Context {
+	return context.WithValue(ctx, metadataKey{}, metadata)
+}
+
+func MetadataFrom(ctx context.Context) es.Metadata {
+	metadata, _ := ctx.Value(metadataKey{}).(es.Metadata)
+	return metadata
+}
+
+// WithTenant makes commands served with the context to see only entities of the tenant.
+func WithTenant(ctx context.Context, tenantId es.TenantId) context.Context {
+	metadata := MetadataFrom(ctx)
+	metadata.TenantId = tenantId
+	return WithMetadata(ctx, metadata)
+}
+
+func WithAppVersion(version string) Option {
+	return func(s *Server) {
+		s.appVersion = version
+	}
+}
+
+// EventFilter selects events of a tenant for audit reports; zero fields match any event.
+type EventFilter struct {
+	EntityType es.EntityType `json:"entity_type,omitempty"`
+	Principal  es.EntityId   `json:"principal,omitempty"`
+	Source     string        `json:"source,omitempty"`
+	From       time.Time     `json:"from,omitempty"`
+	To         time.Time     `json:"to,omitempty"`
+}
+
+func (f EventFilter) Match(event es.Event) bool {
+	return (f.EntityType == "" || f.EntityType == event.EntityType) &&
+		(f.Principal == "" || f.Principal == event.Metadata.Principal) &&
+		(f.Source == "" || f.Source == event.Metadata.Source) &&
+		(f.From.IsZero() || !event.Timestamp.Before(f.From)) &&
+		(f.To.IsZero() || event.Timestamp.Before(f.To))
+}
+
+func schemaVersion(entity es.Entity) int {
+	if versioned, ok := entity.(es.VersionedEntity); ok {
+		return versioned.SchemaVersion()
+	}
+	return 0
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"log"
+
+	"github.com/reillywatson/goloose"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/queue"
+	"github.com/andrew-suprun/legion/queue/durable"
+)
+
+const outboxQueue = "outbox"
+
+// MessageDispatcher delivers messages to their connections; messages are dispatched again
+// after visibility timeout of the outbox if it returns an error.
+type MessageDispatcher func(messages es.Messages) error
+
+type outbox struct {
+	queue    *durable.Queue
+	dispatch MessageDispatcher
+	cancel   context.CancelFunc
+	done     chan bool
+}
+
+// OutboxPersistence is implemented by persistence that stores messages of the outbox
+// and scheduled commands in the same write as the events of the command.
+type OutboxPersistence interface {
+	PersistEventsWithMessages(messages durable.Messages, events ...es.Event) error
+}
+
+// queuedMessage is a message of a durable queue stored with the events of the command.
+type queuedMessage struct {
+	queue   *durable.Queue
+	payload interface{}
+	options []queue.PutOption
+	desc    string
+}
+
+// WithOutbox stores messages of successful commands with their events and hands them
+// to the dispatcher only after that. Messages that were not dispatched, e.g. because of a crash,
+// are dispatched by RunOutbox or DispatchOutbox of the next run.
+func WithOutbox(persistence durable.Persistence, dispatch MessageDispatcher) Option {
+	return func(s *Server) {
+		q, err := durable.New(outboxQueue, persistence, durable.WithClock(s.timeService))
+		if err != nil {
+			log.Panicf("Failed to recover outbox: %v", err)
+		}
+		s.outbox = &outbox{queue: q, dispatch: dispatch}
+	}
+}
+
+// persistEvents stores events of the command and, if it succeeded, messages of the outbox and scheduled commands.
+// Persistence that does not implement OutboxPersistence stores those after the events; as the command
+// is committed by then, failing to store them is reported as a diagnostic rather than a failure.
+func (s *Server) persistEvents(h *commandHelper) {
+	var queued []queuedMessage
+	if h.result.Failure == nil {
+		if s.outbox != nil && len(h.result.Messages) > 0 {
+			queued = append(queued, queuedMessage{queue: s.outbox.queue, payload: h.result.Messages, desc: "Failed to store messages."})
+		}
+		if len(h.result.Scheduled) > 0 {
+			queued = append(queued, s.scheduled(h)...)
+		}
+	}
+	if len(queued) == 0 {
+		h.persistence.PersistEvents(h.result.Events...)
+		return
+	}
+	if p, ok := h.persistence.(OutboxPersistence); ok {
+		messages := make(durable.Messages, len(queued))
+		for i, q := range queued {
+			messages[i] = q.queue.Prepare(q.payload, q.options...)
+		}
+		if err := p.PersistEventsWithMessages(messages, h.result.Events...); err != nil {
+			h.result.Failure = errors.Wrap(err, errors.Failure, DatabaseError, "Failed to store events.")
+			return
+		}
+		// queues fail only when they are closed; stored messages are delivered by the next run then
+		for i, q := range queued {
+			q.queue.Enqueue(messages[i])
+		}
+		return
+	}
+	h.persistence.PersistEvents(h.result.Events...)
+	for _, q := range queued {
+		if err := q.queue.Put(q.payload, q.options...); err != nil {
+			h.result.Diagnostics = append(h.result.Diagnostics, errors.Wrap(err, errors.Diagnostics, DatabaseError, q.desc))
+		}
+	}
+}
+
+// RunOutbox dispatches stored messages in the background until Shutdown.
+func (s *Server) RunOutbox() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.outbox.cancel = cancel
+	s.outbox.done = make(chan bool)
+	go func() {
+		defer close(s.outbox.done)
+		for {
+			delivery, err := s.outbox.queue.GetContext(ctx)
+			if err != nil {
+				return
+			}
+			s.outbox.deliver(delivery.(durable.Delivery))
+		}
+	}()
+}
+
+// DispatchOutbox synchronously dispatches stored messages that are due for delivery.
+func (s *Server) DispatchOutbox() {
+	for {
+		delivery, err := s.outbox.queue.TryGet()
+		if err != nil {
+			return
+		}
+		s.outbox.deliver(delivery.(durable.Delivery))
+	}
+}
+
+// deliver leaves messages the dispatcher failed on in flight, so that they are retried after visibility timeout.
+func (o *outbox) deliver(delivery durable.Delivery) {
+	var messages es.Messages
+	goloose.ToStruct(delivery.Payload, &messages)
+	if err := o.dispatch(messages); err == nil {
+		delivery.Ack()
+	}
+}
+
+func (o *outbox) shutdown() {
+	if o.cancel != nil {
+		o.cancel()
+		<-o.done
+	}
+	o.queue.Close()
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/reillywatson/goloose"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+)
+
+// Policy maps command types to permissions the principal has to be granted through their roles
+// and to conditions on the entities targeted by the command. Policies are plain data and can be loaded from JSON.
+//
+// Conditions have form "<attribute> == <operand>" or "<attribute> != <operand>", where operand is
+// principal (user id of the principal), tenant (tenant of the command) or a JSON literal,
+// e.g. `owner == principal` or `status != "closed"`. Conditions are checked for every entity
+// returned by TargetedCommand.Targets.
+type Policy struct {
+	Roles       map[string][]string     `json:"roles,omitempty"`
+	Commands    map[es.CommandType]Rule `json:"commands,omitempty"`
+	DefaultDeny bool                    `json:"default_deny,omitempty"`
+}
+
+type Rule struct {
+	Permissions []string `json:"permissions,omitempty"`
+	Conditions  []string `json:"conditions,omitempty"`
+}
+
+// AllPermissions granted to a role grants every permission.
+const AllPermissions = "*"
+
+type condition struct {
+	expression string
+	attribute  string
+	equal      bool
+	operand    string
+	literal    interface{}
+}
+
+type policy struct {
+	Policy
+	conditions map[es.CommandType][]condition
+}
+
+// WithPolicy makes the server check the policy before Command.Authorize.
+// With DefaultDeny commands of types missing in the policy are denied; note that this includes
+// framework commands such as saga steps, which have to be listed with no permissions.
+func WithPolicy(p Policy) Option {
+	return func(s *Server) {
+		parsed := &policy{Policy: p, conditions: map[es.CommandType][]condition{}}
+		for cmdType, rule := range p.Commands {
+			for _, expression := range rule.Conditions {
+				c, err := parseCondition(expression)
+				if err != nil {
+					log.Panicf("Invalid policy condition for command %q: %v", cmdType, err)
+				}
+				parsed.conditions[cmdType] = append(parsed.conditions[cmdType], c)
+			}
+		}
+		s.policy = parsed
+	}
+}
+
+func parseCondition(expression string) (condition, error) {
+	c := condition{expression: expression}
+	parts := strings.Fields(expression)
+	if len(parts) < 3 || (parts[1] != "==" && parts[1] != "!=") {
+		return c, fmt.Errorf("expected '<attribute> == <operand>', got %q", expression)
+	}
+	c.attribute = parts[0]
+	c.equal = parts[1] == "=="
+	c.operand = strings.TrimSpace(strings.SplitN(expression, parts[1], 2)[1])
+	if c.operand == "principal" || c.operand == "tenant" {
+		return c, nil
+	}
+	c.literal = decodeLiteral(c.operand)
+	if c.literal == nil && c.operand != "null" {
+		return c, fmt.Errorf("invalid operand in %q", expression)
+	}
+	return c, nil
+}
+
+func decodeLiteral(operand string) interface{} {
+	if s, err := strconv.Unquote(operand); err == nil {
+		return s
+	}
+	if f, err := strconv.ParseFloat(operand, 64); err == nil {
+		return f
+	}
+	if b, err := strconv.ParseBool(operand); err == nil {
+		return b
+	}
+	return nil
+}
+
+// authorize returns errors.Unauthorized failure explaining why the command was denied.
+func (p *policy) authorize(h *commandHelper, cmd Command) error {
+	cmdType := cmd.CommandType()
+	rule, ok := p.Commands[cmdType]
+	if !ok {
+		if p.DefaultDeny {
+			return p.deny(h, cmdType, "Command is not allowed by policy.", nil)
+		}
+		return nil
+	}
+
+	if missing := p.missingPermissions(h.principal, rule.Permissions); len(missing) > 0 {
+		return p.deny(h, cmdType, "Principal lacks permissions required by policy.", es.Info{
+			"required_permissions": rule.Permissions,
+			"missing_permissions":  missing,
+		})
+	}
+
+	conditions := p.conditions[cmdType]
+	if len(conditions) == 0 {
+		return nil
+	}
+	targeted, ok := cmd.(TargetedCommand)
+	if !ok {
+		return p.deny(h, cmdType, "Policy conditions require command to declare its targets.", es.Info{
+			"condition": conditions[0].expression,
+		})
+	}
+	for _, target := range targeted.Targets() {
+		entity, err := h.FetchEntity(target.EntityType, target.EntityId)
+		if err != nil {
+			return err
+		}
+		if entity == nil {
+			return p.deny(h, cmdType, "Target entity does not exist.", es.Info{"entity_type": target.EntityType})
+		}
+		var data es.Info
+		goloose.ToStruct(entity, &data)
+		for _, c := range conditions {
+			if !c.holds(data, h) {
+				// the denial describes the condition only; data of the entity is not the principal's to see
+				return p.deny(h, cmdType, "Policy condition is not satisfied.", es.Info{
+					"condition":   c.expression,
+					"attribute":   c.attribute,
+					"operand":     c.operand,
+					"entity_type": target.EntityType,
+				})
+			}
+		}
+	}
+	return nil
+}
+
+func (p *policy) missingPermissions(principal Principal, required []string) []string {
+	granted := map[string]bool{}
+	for _, role := range principal.Roles {
+		for _, permission := range p.Roles[role] {
+			granted[permission] = true
+		}
+	}
+	if granted[AllPermissions] {
+		return nil
+	}
+	var missing []string
+	for _, permission := range required {
+		if !granted[permission] {
+			missing = append(missing, permission)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+func (c condition) holds(data es.Info, h *commandHelper) bool {
+	var expected interface{}
+	switch c.operand {
+	case "principal":
+		if !h.principal.Authenticated() {
+			return false
+		}
+		expected = string(h.principal.UserId)
+	case "tenant":
+		expected = string(h.result.TenantId)
+	default:
+		expected = c.literal
+	}
+	return (data[c.attribute] == expected) == c.equal
+}
+
+func (p *policy) deny(h *commandHelper, cmdType es.CommandType, reason string, info es.Info) error {
+	return errors.NewError(errors.Failure, errors.Unauthorized, reason, es.Info{
+		"command_type": cmdType,
+		"user_id":      h.principal.UserId,
+		"roles":        h.principal.Roles,
+	}, info)
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/reillywatson/goloose"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/queue"
+	"github.com/andrew-suprun/legion/queue/durable"
+)
+
+const SchedulerNotConfigured errors.ErrorCode = "scheduler_not_configured"
+
+const scheduledCommandsQueue = "scheduled_commands"
+
+// ScheduledCommand is served on behalf of the principal that scheduled it,
+// even after the connection of the principal is gone.
+type ScheduledCommand struct {
+	ScheduledCommandId es.EntityId    `json:"scheduled_command_id" bson:"scheduled_command_id"`
+	ScheduledBy        es.EntityId    `json:"scheduled_by" bson:"scheduled_by"`
+	TenantId           es.TenantId    `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+	CorrelationId      es.EntityId    `json:"correlation_id,omitempty" bson:"correlation_id,omitempty"`
+	CausationId        es.EntityId    `json:"causation_id,omitempty" bson:"causation_id,omitempty"`
+	ConnectionId       es.EntityId    `json:"connection_id" bson:"connection_id"`
+	Principal          Principal      `json:"principal" bson:"principal"`
+	CommandType        es.CommandType `json:"command_type" bson:"command_type"`
+	Info               es.Info        `json:"info,omitempty" bson:"info,omitempty"`
+	Due                time.Time      `json:"due" bson:"due"`
+}
+
+type ScheduledCommands []ScheduledCommand
+
+type scheduler struct {
+	queue  *durable.Queue
+	cancel context.CancelFunc
+	done   chan bool
+}
+
+// WithScheduler lets command handlers schedule commands. Scheduled commands are persisted
+// and survive restarts; they are dispatched by RunScheduler or DispatchScheduled.
+func WithScheduler(persistence durable.Persistence) Option {
+	return func(s *Server) {
+		q, err := durable.New(scheduledCommandsQueue, persistence, durable.WithClock(s.timeService))
+		if err != nil {
+			log.Panicf("Failed to recover scheduled commands: %v", err)
+		}
+		s.scheduler = &scheduler{queue: q}
+	}
+}
+
+// scheduled prepares the commands scheduled by the command as caused by its first event.
+func (s *Server) scheduled(h *commandHelper) (messages []queuedMessage) {
+	causationId := h.result.CausedBy()
+	for i := range h.result.Scheduled {
+		h.result.Scheduled[i].CausationId = causationId
+		command := h.result.Scheduled[i]
+		messages = append(messages, queuedMessage{
+			queue:   s.scheduler.queue,
+			payload: command,
+			options: []queue.PutOption{queue.NotBefore(command.Due)},
+			desc:    "Failed to schedule command.",
+		})
+	}
+	return messages
+}
+
+// RunScheduler dispatches scheduled commands in the background as they become due until Shutdown.
+func (s *Server) RunScheduler() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.scheduler.cancel = cancel
+	s.scheduler.done = make(chan bool)
+	go func() {
+		defer close(s.scheduler.done)
+		for {
+			delivery, err := s.scheduler.queue.GetContext(ctx)
+			if err != nil {
+				return
+			}
+			s.dispatch(delivery.(durable.Delivery))
+		}
+	}()
+}
+
+// DispatchScheduled synchronously serves all the scheduled commands due by now.
+// Tests call it after moving time forward.
+func (s *Server) DispatchScheduled() (results []*ServiceResult) {
+	s.scheduler.queue.Refresh()
+	for {
+		delivery, err := s.scheduler.queue.TryGet()
+		if err != nil {
+			return results
+		}
+		if result := s.dispatch(delivery.(durable.Delivery)); result != nil {
+			results = append(results, result)
+		}
+	}
+}
+
+// dispatch acks the scheduled command once it was served, even if it failed;
+// only panicked commands are retried.
+func (s *Server) dispatch(delivery durable.Delivery) *ServiceResult {
+	var command ScheduledCommand
+	goloose.ToStruct(delivery.Payload, &command)
+	ctx := WithPrincipal(WithTenant(context.Background(), command.TenantId), command.Principal)
+	ctx = WithCorrelation(ctx, command.CorrelationId, command.CausationId)
+	result := (<-s.ServeContext(ctx, command.ConnectionId, command.CommandType, command.Info)).(*ServiceResult)
+	if result.Panic != nil {
+		delivery.Nack()
+	} else {
+		delivery.Ack()
+	}
+	return result
+}
+
+func (s *scheduler) shutdown() {
+	if s.cancel != nil {
+		s.cancel()
+		<-s.done
+	}
+	s.queue.Close()
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"log"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/validation"
+)
+
+// WithSchema validates Info of commands of the type before CommandFactory is called.
+func WithSchema(cmdType es.CommandType, schema *validation.Schema) Option {
+	if err := schema.Compile(); err != nil {
+		log.Panicf("Invalid schema of %s: %v", cmdType, err)
+	}
+	return func(s *Server) {
+		if s.schemas == nil {
+			s.schemas = map[es.CommandType]*validation.Schema{}
+		}
+		s.schemas[cmdType] = schema
+	}
+}
+
+// validateInfo reports every schema violation as a diagnostic of the result.
+func (s *Server) validateInfo(ctx context.Context, cmdType es.CommandType, info es.Info, result *ServiceResult) error {
+	schema, ok := s.schemas[cmdType]
+	if !ok {
+		return nil
+	}
+	violations := schema.Validate(info)
+	if len(violations) == 0 {
+		return nil
+	}
+	result.Diagnostics = append(result.Diagnostics, translateErrors(Locale(ctx), violations)...)
+	return errors.NewError(errors.Failure, errors.InvalidRequest, "Command does not match its schema.", es.Info{
+		"command_type": cmdType,
+		"violations":   len(violations),
+	})
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"log"
 
 	"github.com/andrew-suprun/legion/aggregates"
 
@@ -11,6 +12,7 @@ import (
 	"github.com/andrew-suprun/legion/es"
 	"github.com/andrew-suprun/legion/json"
 	"github.com/andrew-suprun/legion/tasks"
+	"github.com/andrew-suprun/legion/validation"
 
 	"sync"
 	"time"
@@ -27,6 +29,41 @@ type Server struct {
 	timeService    TimeService
 	persistence    Persistence
 	commandFactory CommandFactory
+	entityActors   *entityActors
+	scheduler      *scheduler
+	outbox         *outbox
+	idempotency    *idempotency
+	schemas        map[es.CommandType]*validation.Schema
+	reactors       []Reactor
+	appVersion     string
+
+	authenticator   Authenticator
+	policy          *policy
+	connectionsLock sync.Mutex
+	connections     map[es.EntityId]Principal
+}
+
+type Option func(s *Server)
+
+// WithEntityActors routes commands that implement TargetedCommand through per-entity actors.
+// Actors idle for longer than idleTimeout are passivated and their cached entities are dropped.
+func WithEntityActors(idleTimeout time.Duration) Option {
+	if idleTimeout <= 0 {
+		log.Panicf("Idle timeout of entity actors must be positive, got %v", idleTimeout)
+	}
+	return func(s *Server) {
+		s.entityActors = newEntityActors(idleTimeout)
+	}
+}
+
+// Reactor is called with results of commands that successfully persisted events
+// before the result is delivered; reactors should hand long running work off to other goroutines.
+type Reactor func(result *ServiceResult)
+
+func WithReactor(reactor Reactor) Option {
+	return func(s *Server) {
+		s.reactors = append(s.reactors, reactor)
+	}
 }
 
 type TimeService interface {
@@ -38,6 +75,11 @@ type Persistence interface {
 	PersistEvents(events ...es.Event)
 	FetchEntity(et es.EntityType, id es.EntityId) (es.Entity, error)
 	FetchEntityAt(et es.EntityType, id es.EntityId, timestamp time.Time) (es.Entity, error)
+	FetchCausalChain(correlationId es.EntityId) (es.Events, error)
+	FetchEvents(filter EventFilter) (es.Events, error)
+
+	// Tenant returns persistence that stores and fetches events of the tenant only.
+	Tenant(tenantId es.TenantId) Persistence
 }
 
 type CommandFactory func(cmdType es.CommandType, info es.Info) (Command, error)
@@ -53,11 +95,14 @@ type Command interface {
 type CommandHelper interface {
 	Context() context.Context
 	ConnectionId() es.EntityId
+	Principal() Principal
+	Locale() string
 	CreateEntity(entity es.Entity)
 	FetchEntity(et es.EntityType, id es.EntityId) (es.Entity, error)
 	FetchEntityAt(et es.EntityType, id es.EntityId, timestamp time.Time) (es.Entity, error)
 	Reply(MessageType es.MessageType, info ...es.Info)
 	AddDiagnostic(code errors.ErrorCode, desc string, info ...es.Info)
+	ScheduleCommand(cmdType es.CommandType, info es.Info, due time.Time) es.EntityId
 
 	// TODO: extract those two methods into separate services
 	Now() time.Time
@@ -65,70 +110,187 @@ type CommandHelper interface {
 }
 
 type ServiceResult struct {
-	CommandId    es.EntityId   `json:"command_id"`
-	ConnectionId es.EntityId   `json:"connection_id"`
-	Command      Command       `json:"command,omitempty"`
-	Events       es.Events     `json:"events,omitempty"`
-	Messages     es.Messages   `json:"messages,omitempty"`
-	Diagnostics  errors.Errors `json:"diagnostics,omitempty"`
-	Failure      error         `json:"failure,omitempty"`
-	Panic        interface{}   `json:"panic,omitempty"`
+	CommandId     es.EntityId       `json:"command_id"`
+	TenantId      es.TenantId       `json:"tenant_id,omitempty"`
+	ConnectionId  es.EntityId       `json:"connection_id"`
+	CorrelationId es.EntityId       `json:"correlation_id,omitempty"`
+	CausationId   es.EntityId       `json:"causation_id,omitempty"`
+	Principal     Principal         `json:"principal"` // principal the command was served for
+	Command       Command           `json:"command,omitempty"`
+	Events        es.Events         `json:"events,omitempty"`
+	Messages      es.Messages       `json:"messages,omitempty"`
+	Diagnostics   errors.Errors     `json:"diagnostics,omitempty"`
+	Scheduled     ScheduledCommands `json:"scheduled,omitempty"`
+	Duplicate     bool              `json:"duplicate,omitempty"` // replayed result of a retried command
+	Failure       error             `json:"failure,omitempty"`
+	Panic         interface{}       `json:"panic,omitempty"`
 }
 
 func (r *ServiceResult) String() string {
 	return json.Encode(r)
 }
 
+// CausedBy returns the causation of commands triggered by the result:
+// its first event or, for results without events, the event that caused the command.
+func (r *ServiceResult) CausedBy() es.EntityId {
+	if len(r.Events) > 0 {
+		return es.EntityId(r.Events[0].EventId)
+	}
+	return r.CausationId
+}
+
 const (
 	failure es.MessageType = "failure"
 )
 
-func New(timeService TimeService, persistence Persistence, commandFactory CommandFactory) *Server {
-	return &Server{
+func New(timeService TimeService, persistence Persistence, commandFactory CommandFactory, options ...Option) *Server {
+	s := &Server{
 		timeService:    timeService,
 		persistence:    persistence,
 		commandFactory: commandFactory,
+		connections:    map[es.EntityId]Principal{},
+	}
+	for _, option := range options {
+		option(s)
 	}
+	return s
 }
 
 func (s *Server) Shutdown() {
 	// TODO: track requests in flight
+	if s.entityActors != nil {
+		s.entityActors.shutdown()
+	}
+	if s.scheduler != nil {
+		s.scheduler.shutdown()
+	}
+	if s.outbox != nil {
+		s.outbox.shutdown()
+	}
 }
 
-func (s *Server) Serve(connId es.EntityId, cmdType es.CommandType, cmdInfo es.Info) (resultChan chan interface{}) {
-	result := &ServiceResult{
-		ConnectionId: connId,
-		CommandId:    es.NewEntityId(),
-	}
+func (s *Server) Serve(tenantId es.TenantId, connId es.EntityId, cmdType es.CommandType, cmdInfo es.Info) (resultChan chan interface{}) {
+	return s.ServeContext(WithTenant(context.Background(), tenantId), connId, cmdType, cmdInfo)
+}
+
+// ServeContext serves the command as part of the causal chain set up with WithCorrelation;
+// without it the command starts a new chain correlated by its own id.
+// The command is served for the tenant set up with WithTenant.
+func (s *Server) ServeContext(ctx context.Context, connId es.EntityId, cmdType es.CommandType, cmdInfo es.Info) (resultChan chan interface{}) {
+	result := newResult(ctx, connId)
 
-	cmd, err := s.commandFactory(cmdType, cmdInfo)
+	err := s.validateInfo(ctx, cmdType, cmdInfo, result)
+	var cmd Command
+	if err == nil {
+		cmd, err = s.commandFactory(cmdType, cmdInfo)
+	}
 	if err != nil {
-		result.Failure = err
+		result.Failure = translateError(Locale(ctx), err)
 		resultChan = make(chan interface{}, 1)
 		resultChan <- result
 		return resultChan
 	}
+	return s.serve(ctx, cmd, result)
+}
+
+// ServeCommand serves already constructed command; used for commands internal to the framework.
+func (s *Server) ServeCommand(ctx context.Context, connId es.EntityId, cmd Command) (resultChan chan interface{}) {
+	return s.serve(ctx, cmd, newResult(ctx, connId))
+}
+
+func newResult(ctx context.Context, connId es.EntityId) *ServiceResult {
+	result := &ServiceResult{
+		ConnectionId:  connId,
+		CommandId:     es.NewEntityId(),
+		TenantId:      MetadataFrom(ctx).TenantId,
+		CorrelationId: CorrelationId(ctx),
+		CausationId:   CausationId(ctx),
+	}
+	if result.CorrelationId == "" {
+		result.CorrelationId = result.CommandId
+	}
+	return result
+}
+
+func (s *Server) serve(ctx context.Context, cmd Command, result *ServiceResult) (resultChan chan interface{}) {
 	result.Command = cmd
+	run := s.execute
+	if s.idempotency != nil && IdempotencyKey(ctx) != "" {
+		run = s.serveOnce
+	}
+	preferred := Locale(ctx)
+	if preferred == "" {
+		return run(ctx, cmd, result)
+	}
+	served := run(ctx, cmd, result)
+	return tasks.Start(func() interface{} {
+		result := (<-served).(*ServiceResult)
+		result.Failure = translateError(preferred, result.Failure)
+		return result
+	})
+}
+
+func (s *Server) execute(ctx context.Context, cmd Command, result *ServiceResult) (resultChan chan interface{}) {
 
 	activityResultChan := tasks.Start(
 		func() interface{} {
+			principal, err := s.principal(ctx, result)
+			if err != nil {
+				result.Failure = err
+				return result
+			}
+			result.Principal = principal
 			h := &commandHelper{
+				ctx:         WithCorrelation(ctx, result.CorrelationId, result.CausationId),
+				metadata:    MetadataFrom(ctx),
+				principal:   principal,
+				locale:      Locale(ctx),
 				timeService: s.timeService,
-				persistence: s.persistence,
+				scheduling:  s.scheduler != nil,
+				persistence: s.persistence.Tenant(result.TenantId),
 				entities:    map[es.EntityId]es.Entity{},
+				entityData:  map[es.EntityId]es.Info{},
 				result:      result,
 			}
+			h.metadata.AppVersion = s.appVersion
+			h.metadata.TenantId = result.TenantId
+			h.metadata.Principal = principal.UserId
+			if targeted, ok := cmd.(TargetedCommand); ok && s.entityActors != nil {
+				held, release, err := s.entityActors.acquire(ctx, result.TenantId, targeted.Targets())
+				if err != nil {
+					h.result.Failure = err
+					return h.result
+				}
+				defer release()
+				h.held = held
+				h.cached, h.versions = s.entityActors.take(held)
+			}
 			h.result.Failure = cmd.Validate(h)
 			if h.result.Failure != nil {
 				return h.result
 			}
+			if s.policy != nil {
+				h.result.Failure = s.policy.authorize(h, cmd)
+				if h.result.Failure != nil {
+					return h.result
+				}
+			}
 			h.result.Failure = cmd.Authorize(h)
 			if h.result.Failure != nil {
 				return h.result
 			}
 			h.result.Failure = cmd.Handle(h)
+			if h.result.Failure == nil {
+				h.result.Failure = h.scheduleFailure
+			}
 			h.createEventsFromEntities()
-			h.persistence.PersistEvents(h.result.Events...)
+			s.persistEvents(h)
+			if s.entityActors != nil {
+				s.entityActors.invalidate(result.TenantId, h.result.Events, h.held)
+			}
+			if h.held != nil && h.result.Failure == nil {
+				s.entityActors.cache(h.held, h.versions, h.entities)
+			}
 			return h.result
 		},
 	)
@@ -144,6 +306,16 @@ func (s *Server) Serve(connId es.EntityId, cmdType es.CommandType, cmdInfo es.In
 				result.Failure = v
 			}
 
+			// messages of failed commands are never delivered
+			if result.Failure != nil {
+				result.Messages = nil
+			}
+
+			if result.Failure == nil && len(result.Events) > 0 {
+				for _, reactor := range s.reactors {
+					reactor(result)
+				}
+			}
 			return result
 		},
 	)
@@ -152,11 +324,20 @@ func (s *Server) Serve(connId es.EntityId, cmdType es.CommandType, cmdInfo es.In
 type commandHelper struct {
 	lock        sync.Mutex
 	ctx         context.Context
+	metadata    es.Metadata
+	principal   Principal
+	locale      string
 	timeService TimeService
+	scheduling  bool
 	persistence Persistence
 	result      *ServiceResult
-	entities    map[es.EntityId]es.Entity
-	entityData  map[es.EntityId]es.Info
+	// scheduleFailure fails the command if it scheduled commands without a scheduler
+	scheduleFailure error
+	entities        map[es.EntityId]es.Entity
+	entityData      map[es.EntityId]es.Info
+	held            map[tenantKey]*entityActor
+	cached          map[EntityKey]es.Entity
+	versions        map[tenantKey]int
 }
 
 func (h *commandHelper) Now() time.Time {
@@ -173,6 +354,14 @@ func (h *commandHelper) ConnectionId() es.EntityId {
 	return h.result.ConnectionId
 }
 
+func (h *commandHelper) Principal() Principal {
+	return h.principal
+}
+
+func (h *commandHelper) Locale() string {
+	return h.locale
+}
+
 func (h *commandHelper) CreateEntity(entity es.Entity) {
 	h.lock.Lock()
 	h.entities[entity.EntityId()] = entity
@@ -181,12 +370,22 @@ func (h *commandHelper) CreateEntity(entity es.Entity) {
 
 func (h *commandHelper) Reply(messageType es.MessageType, infos ...es.Info) {
 	h.lock.Lock()
-	h.result.Messages = append(h.result.Messages, es.Message{ConnectionId: h.result.ConnectionId, MessageType: messageType, Info: mergeInfos(infos...)})
+	h.result.Messages = append(h.result.Messages, es.Message{
+		ConnectionId:  h.result.ConnectionId,
+		MessageType:   messageType,
+		CorrelationId: h.result.CorrelationId,
+		CausationId:   h.result.CausationId,
+		Info:          translateReply(h.locale, mergeInfos(infos...)),
+	})
 	h.lock.Unlock()
 }
 
 func (h *commandHelper) SendMessage(message es.Message) {
 	h.lock.Lock()
+	if message.CorrelationId == "" {
+		message.CorrelationId = h.result.CorrelationId
+		message.CausationId = h.result.CausationId
+	}
 	h.result.Messages = append(h.result.Messages, message)
 	h.lock.Unlock()
 }
@@ -198,7 +397,7 @@ func (h *commandHelper) FetchEntity(et es.EntityType, id es.EntityId) (es.Entity
 	if ok && entity.EntityType() == et {
 		return entity, nil
 	}
-	entity, err := h.persistence.FetchEntity(et, id)
+	entity, err := h.fetchEntity(et, id)
 	if err != nil {
 		return nil, err
 	}
@@ -214,6 +413,13 @@ func (h *commandHelper) FetchEntity(et es.EntityType, id es.EntityId) (es.Entity
 	return entity, nil
 }
 
+func (h *commandHelper) fetchEntity(et es.EntityType, id es.EntityId) (es.Entity, error) {
+	if entity, ok := h.cached[EntityKey{EntityType: et, EntityId: id}]; ok {
+		return entity, nil
+	}
+	return h.persistence.FetchEntity(et, id)
+}
+
 func (h *commandHelper) FetchEntityAt(et es.EntityType, id es.EntityId, timestamp time.Time) (es.Entity, error) {
 	entity, err := h.persistence.FetchEntityAt(et, id, timestamp)
 	if err != nil {
@@ -224,24 +430,53 @@ func (h *commandHelper) FetchEntityAt(et es.EntityType, id es.EntityId, timestam
 
 func (h *commandHelper) AddDiagnostic(code errors.ErrorCode, desc string, info ...es.Info) {
 	h.lock.Lock()
-	h.result.Diagnostics = append(h.result.Diagnostics, errors.NewError(errors.Diagnostics, code, desc, info...))
+	diagnostic := translateError(h.locale, errors.NewError(errors.Diagnostics, code, desc, info...)).(errors.Error)
+	h.result.Diagnostics = append(h.result.Diagnostics, diagnostic)
 	h.lock.Unlock()
 }
 
+// ScheduleCommand fails the scheduling command if the server is not configured to schedule commands.
+func (h *commandHelper) ScheduleCommand(cmdType es.CommandType, info es.Info, due time.Time) es.EntityId {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if !h.scheduling {
+		h.scheduleFailure = errors.NewError(errors.Failure, SchedulerNotConfigured, "Server is not configured to schedule commands.")
+		return ""
+	}
+	command := ScheduledCommand{
+		ScheduledCommandId: es.NewEntityId(),
+		ScheduledBy:        h.result.CommandId,
+		TenantId:           h.result.TenantId,
+		CorrelationId:      h.result.CorrelationId,
+		ConnectionId:       h.result.ConnectionId,
+		Principal:          h.principal,
+		CommandType:        cmdType,
+		Info:               info,
+		Due:                due,
+	}
+	h.result.Scheduled = append(h.result.Scheduled, command)
+	return command.ScheduledCommandId
+}
+
 func (h *commandHelper) createEventsFromEntities() {
 	for _, entity := range h.entities {
 		originalData := h.entityData[entity.EntityId()]
 		var updatedData es.Info
 		goloose.ToStruct(entity, &updatedData)
 		diff := aggregates.Diff(originalData, updatedData)
+		metadata := h.metadata
+		metadata.SchemaVersion = schemaVersion(entity)
 		h.result.Events = append(h.result.Events, es.Event{
-			EventId:     es.NewEventId(),
-			CommandType: h.result.Command.CommandType(),
-			CommandId:   h.result.CommandId,
-			EntityType:  entity.EntityType(),
-			EntityId:    entity.EntityId(),
-			Timestamp:   h.timeService.Now(),
-			Info:        diff,
+			EventId:       es.NewEventId(),
+			CommandType:   h.result.Command.CommandType(),
+			CommandId:     h.result.CommandId,
+			EntityType:    entity.EntityType(),
+			EntityId:      entity.EntityId(),
+			CorrelationId: h.result.CorrelationId,
+			CausationId:   h.result.CausationId,
+			Timestamp:     h.timeService.Now(),
+			Metadata:      metadata,
+			Info:          diff,
 		})
 	}
 }
@@ -1,7 +1,9 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -11,7 +13,7 @@ import (
 
 func TestInvalidCommand(t *testing.T) {
 	s := New(testTimeService{}, &testPersistence{}, testCommandFactory)
-	resultChan := s.Serve("conn", "invalid", nil)
+	resultChan := s.Serve("", "conn", "invalid", nil)
 	result := (<-resultChan).(*ServiceResult)
 	if result.Failure == nil {
 		fmt.Println("Unexpectedly succeeded.")
@@ -21,13 +23,98 @@ func TestInvalidCommand(t *testing.T) {
 
 func TestValidCommand(t *testing.T) {
 	s := New(testTimeService{}, &testPersistence{}, testCommandFactory)
-	resultChan := s.Serve("conn", "valid", nil)
+	resultChan := s.Serve("", "conn", "valid", nil)
 	result := (<-resultChan).(*ServiceResult)
 	if result.Failure != nil {
 		t.Fatalf("Unexpectedly failed.")
 	}
 }
 
+func TestEntityActorsSerializeCommands(t *testing.T) {
+	s := New(testTimeService{}, &testPersistence{}, testCommandFactory, WithEntityActors(time.Second))
+	defer s.Shutdown()
+
+	var resultChans []chan interface{}
+	for i := 0; i < 10; i++ {
+		resultChans = append(resultChans, s.Serve("", "conn", "targeted", nil))
+	}
+	for _, resultChan := range resultChans {
+		result := (<-resultChan).(*ServiceResult)
+		if result.Failure != nil {
+			t.Fatalf("Unexpectedly failed: %v", result.Failure)
+		}
+	}
+	if maxRunning := atomic.LoadInt32(&targetedMaxRunning); maxRunning != 1 {
+		t.Fatalf("Expected sequential execution, got %d concurrent commands.", maxRunning)
+	}
+}
+
+type testEntity struct{ id es.EntityId }
+
+func (e testEntity) EntityId() es.EntityId     { return e.id }
+func (e testEntity) EntityType() es.EntityType { return "test" }
+
+func TestEntityActorsDropEntitiesWrittenByOtherCommands(t *testing.T) {
+	ea := newEntityActors(time.Second)
+	defer ea.shutdown()
+	key := EntityKey{EntityType: "test", EntityId: "test-1"}
+	written := es.Events{{EntityType: "test", EntityId: "test-1"}}
+
+	held, release, _ := ea.acquire(context.Background(), "tenant", []EntityKey{key})
+	_, versions := ea.take(held)
+	ea.cache(held, versions, map[es.EntityId]es.Entity{"test-1": testEntity{"test-1"}})
+	release()
+
+	ea.invalidate("tenant", written, nil)
+	held, release, _ = ea.acquire(context.Background(), "tenant", []EntityKey{key})
+	cached, versions := ea.take(held)
+	if len(cached) != 0 {
+		t.Fatalf("Expected entity written by other command to be dropped, got %v", cached)
+	}
+	ea.invalidate("tenant", written, nil)
+	ea.cache(held, versions, map[es.EntityId]es.Entity{"test-1": testEntity{"test-1"}})
+	release()
+
+	held, release, _ = ea.acquire(context.Background(), "tenant", []EntityKey{key})
+	defer release()
+	if cached, _ := ea.take(held); len(cached) != 0 {
+		t.Fatalf("Expected entity written while the command was running not to be cached, got %v", cached)
+	}
+}
+
+func TestEntityActorsShutdownWaitsForHolds(t *testing.T) {
+	ea := newEntityActors(time.Second)
+	_, release, _ := ea.acquire(context.Background(), "tenant", []EntityKey{{EntityType: "test", EntityId: "test-1"}})
+
+	stopped := make(chan bool)
+	go func() {
+		ea.shutdown()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("Expected shutdown to wait for the command holding the entity")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if _, _, err := ea.acquire(context.Background(), "tenant", []EntityKey{{EntityType: "test", EntityId: "test-2"}}); err == nil {
+		t.Fatal("Expected commands to be rejected during shutdown")
+	}
+	release()
+	<-stopped
+	if len(ea.actors) != 0 {
+		t.Fatalf("Expected all the actors to be passivated, got %d", len(ea.actors))
+	}
+}
+
+func TestEntityActorsRejectNonPositiveIdleTimeout(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected panic")
+		}
+	}()
+	WithEntityActors(0)
+}
+
 type testTimeService struct{}
 
 func (ts testTimeService) Now() time.Time {
@@ -48,10 +135,25 @@ func (p *testPersistence) FetchEntityAt(et es.EntityType, id es.EntityId, timest
 	return nil, nil
 }
 
+func (p *testPersistence) FetchCausalChain(correlationId es.EntityId) (es.Events, error) {
+	return nil, nil
+}
+
+func (p *testPersistence) FetchEvents(filter EventFilter) (es.Events, error) {
+	return nil, nil
+}
+
+func (p *testPersistence) Tenant(tenantId es.TenantId) Persistence {
+	return p
+}
+
 func testCommandFactory(cmdType es.CommandType, info es.Info) (Command, error) {
 	if cmdType == "valid" {
 		return testCommand{}, nil
 	}
+	if cmdType == "targeted" {
+		return targetedCommand{}, nil
+	}
 	return nil, errors.NewError(errors.Alert, InvalidCommand, "invalid")
 }
 
@@ -72,3 +174,26 @@ func (testCommand) Authorize(helper CommandHelper) error {
 func (testCommand) Handle(helper CommandHelper) error {
 	return nil
 }
+
+var targetedRunning, targetedMaxRunning int32
+
+type targetedCommand struct {
+	testCommand
+}
+
+func (targetedCommand) Targets() []EntityKey {
+	return []EntityKey{{EntityType: "test", EntityId: "test-1"}}
+}
+
+func (targetedCommand) Handle(helper CommandHelper) error {
+	running := atomic.AddInt32(&targetedRunning, 1)
+	defer atomic.AddInt32(&targetedRunning, -1)
+	for {
+		maxRunning := atomic.LoadInt32(&targetedMaxRunning)
+		if running <= maxRunning || atomic.CompareAndSwapInt32(&targetedMaxRunning, maxRunning, running) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond)
+	return nil
+}
@@ -0,0 +1,48 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andrew-suprun/legion/auth"
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/server"
+)
+
+type whoAmI struct{}
+
+func (whoAmI) CommandType() es.CommandType                { return "who_am_i" }
+func (whoAmI) Validate(helper server.CommandHelper) error { return nil }
+func (whoAmI) Authorize(helper server.CommandHelper) error {
+	if !helper.Principal().HasRole("user") {
+		return errors.NewError(errors.Failure, errors.Unauthorized, "Not a user.")
+	}
+	return nil
+}
+func (whoAmI) Handle(helper server.CommandHelper) error {
+	helper.Reply("you_are", es.Info{"user_id": helper.Principal().UserId})
+	return nil
+}
+
+func TestAuthenticatedConnection(t *testing.T) {
+	authenticator := auth.NewJWTAuthenticator([]byte("secret"))
+	test := NewTest(t, func(es.CommandType, es.Info) (server.Command, error) { return whoAmI{}, nil }, noEntities,
+		server.WithAuthenticator(authenticator))
+	defer test.Shutdown()
+
+	test.Send("conn", "who_am_i", nil).CheckFailed()
+
+	token := authenticator.Sign(server.Principal{UserId: "user-1", TenantId: test.TenantId, Roles: []string{"user"}}, time.Now().Add(time.Hour))
+	if _, err := test.Connect("conn", server.Credentials{Token: token}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	messages := test.Send("conn", "who_am_i", nil).CheckSucceeded().Messages()
+	if messages[0].Info["user_id"] != es.EntityId("user-1") {
+		t.Fatalf("Unexpected reply: %v", messages)
+	}
+	test.NewTenant().Send("conn", "who_am_i", nil).CheckFailed()
+
+	test.Disconnect("conn")
+	test.Send("conn", "who_am_i", nil).CheckFailed()
+}
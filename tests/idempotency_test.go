@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"context"
+	stderrors "errors"
+	"testing"
+	"time"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/persistence/in_memory"
+	"github.com/andrew-suprun/legion/server"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	test := NewTest(t, tenantCommandFactory, accountEntityFactory)
+	defer test.Shutdown()
+
+	serve := func(key string, cmdType es.CommandType, info es.Info) *server.ServiceResult {
+		ctx := server.WithIdempotencyKey(server.WithTenant(context.Background(), test.TenantId), key)
+		return (<-test.ServeContext(ctx, "conn", cmdType, info)).(*server.ServiceResult)
+	}
+
+	first := serve("key-1", "open_account", es.Info{"name": "Savings"})
+	retried := serve("key-1", "open_account", es.Info{"name": "Savings"})
+	if !retried.Duplicate || retried.CommandId != first.CommandId || retried.Events[0].EntityId != first.Events[0].EntityId {
+		t.Fatalf("Expected stored result, got %v", retried)
+	}
+	if events, _ := test.FetchEvents(server.EventFilter{EntityType: "account"}); len(events) != 1 {
+		t.Fatalf("Expected command to be executed once, got %v", events)
+	}
+
+	other := test.NewTenant()
+	ctx := server.WithIdempotencyKey(server.WithTenant(context.Background(), other.TenantId), "key-1")
+	if result := (<-other.ServeContext(ctx, "conn", "open_account", es.Info{"name": "Savings"})).(*server.ServiceResult); result.Duplicate {
+		t.Fatalf("Expected idempotency keys to be scoped by tenant.")
+	}
+
+	reused := serve("key-1", "find_account", es.Info{"account_id": "a"})
+	if failure, ok := reused.Failure.(errors.Error); !ok || failure.Code != server.IdempotencyKeyReused {
+		t.Fatalf("Expected reused key to fail, got %v", reused.Failure)
+	}
+
+	test.SetNow(time.Now().Add(IdempotencyRetention + time.Hour))
+	if expired := serve("key-1", "open_account", es.Info{"name": "Savings"}); expired.Duplicate || expired.CommandId == first.CommandId {
+		t.Fatalf("Expected command to be executed again after retention, got %v", expired)
+	}
+}
+
+func TestConcurrentIdempotencyKey(t *testing.T) {
+	test := NewTest(t, accountCommandFactory, accountEntityFactory)
+	defer test.Shutdown()
+
+	ctx := server.WithIdempotencyKey(server.WithTenant(context.Background(), test.TenantId), "key")
+	var resultChans []chan interface{}
+	for i := 0; i < 10; i++ {
+		resultChans = append(resultChans, test.ServeContext(ctx, "conn", "open_account", es.Info{"name": "Savings"}))
+	}
+	duplicates := 0
+	for _, resultChan := range resultChans {
+		if (<-resultChan).(*server.ServiceResult).Duplicate {
+			duplicates++
+		}
+	}
+	if duplicates != 9 {
+		t.Fatalf("Expected command to be executed once, got %d duplicates", duplicates)
+	}
+}
+
+type failingIdempotencyStore struct {
+	server.IdempotencyStore
+}
+
+func (failingIdempotencyStore) PersistIdempotentResult(result server.IdempotentResult) error {
+	return stderrors.New("connection refused")
+}
+
+func TestIdempotentResultStoreFailure(t *testing.T) {
+	p := in_memory.NewPersistence(accountEntityFactory)
+	s := server.New(&testTimeService{}, p, accountCommandFactory,
+		server.WithIdempotency(failingIdempotencyStore{p.(server.IdempotencyStore)}, IdempotencyRetention))
+	defer s.Shutdown()
+
+	tenantId := es.TenantId(es.NewEntityId())
+	ctx := server.WithIdempotencyKey(server.WithTenant(context.Background(), tenantId), "key")
+	committed := (<-s.ServeContext(ctx, "conn", "open_account", es.Info{"name": "Savings"})).(*server.ServiceResult)
+	if committed.Failure != nil || len(committed.Diagnostics) != 1 || committed.Diagnostics[0].Code != server.DatabaseError {
+		t.Fatalf("Expected committed command to succeed with diagnostic, got %v", committed)
+	}
+	retried := (<-s.ServeContext(ctx, "conn", "open_account", es.Info{"name": "Savings"})).(*server.ServiceResult)
+	if failure, ok := retried.Failure.(errors.Error); !ok || failure.Code != server.IdempotentCommandInProgress {
+		t.Fatalf("Expected retry not to execute the command again, got %v", retried.Failure)
+	}
+	if events, _ := p.Tenant(tenantId).FetchEvents(server.EventFilter{EntityType: "account"}); len(events) != 1 {
+		t.Fatalf("Expected command to be executed once, got %v", events)
+	}
+}
+
+func TestAbandonedIdempotencyReservation(t *testing.T) {
+	ts := &testTimeService{}
+	p := in_memory.NewPersistence(accountEntityFactory)
+	s := server.New(ts, p, accountCommandFactory, server.WithIdempotency(p.(server.IdempotencyStore), IdempotencyRetention))
+	defer s.Shutdown()
+
+	// the server reserved the key and crashed before storing the result
+	tenantId := es.TenantId(es.NewEntityId())
+	reservation := server.IdempotentResult{TenantId: tenantId, Key: "key", CommandType: "open_account", CompletedAt: ts.Now(), Pending: true}
+	if reserved, err := p.(server.IdempotencyStore).ReserveIdempotencyKey(reservation, ts.Now().Add(-IdempotencyRetention), ts.Now().Add(-server.IdempotencyLease)); !reserved || err != nil {
+		t.Fatalf("Failed to reserve key: %v", err)
+	}
+
+	ctx := server.WithIdempotencyKey(server.WithTenant(context.Background(), tenantId), "key")
+	retried := (<-s.ServeContext(ctx, "conn", "open_account", es.Info{"name": "Savings"})).(*server.ServiceResult)
+	if failure, ok := retried.Failure.(errors.Error); !ok || failure.Code != server.IdempotentCommandInProgress {
+		t.Fatalf("Expected reservation to hold the key during the lease, got %v", retried.Failure)
+	}
+	ts.SetNow(time.Now().Add(server.IdempotencyLease + time.Second))
+	if retried := (<-s.ServeContext(ctx, "conn", "open_account", es.Info{"name": "Savings"})).(*server.ServiceResult); retried.Failure != nil || retried.Duplicate {
+		t.Fatalf("Expected retry to take over the abandoned reservation, got %v", retried)
+	}
+}
+
+func TestIdempotencyKeyOfFailedCommand(t *testing.T) {
+	test := NewTest(t, transferCommandFactory, accountEntityFactory)
+	defer test.Shutdown()
+
+	ctx := server.WithIdempotencyKey(server.WithTenant(context.Background(), test.TenantId), "key")
+	for i := 0; i < 2; i++ {
+		result := (<-test.ServeContext(ctx, "conn", "transfer", es.Info{"amount": -1.0})).(*server.ServiceResult)
+		if failure, ok := result.Failure.(errors.Error); !ok || failure.Code != errors.InvalidRequest || result.Duplicate {
+			t.Fatalf("Expected failed command to be executed again, got %v", result.Failure)
+		}
+	}
+}
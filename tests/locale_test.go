@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/locale"
+	"github.com/andrew-suprun/legion/server"
+)
+
+type transfer struct {
+	Amount float64
+}
+
+func (transfer) CommandType() es.CommandType { return "transfer" }
+
+func (c transfer) Validate(helper server.CommandHelper) error {
+	if c.Amount <= 0 {
+		return errors.NewError(errors.Failure, errors.InvalidRequest, "Amount must be positive.", es.Info{"amount": c.Amount})
+	}
+	return nil
+}
+
+func (transfer) Authorize(helper server.CommandHelper) error { return nil }
+
+func (c transfer) Handle(helper server.CommandHelper) error {
+	helper.AddDiagnostic("low_balance", "Balance is low: {balance, currency}.", es.Info{"balance": 5, "currency": "EUR"})
+	helper.Reply("transferred", es.Info{
+		server.MessageText: "Transferred {amount, currency}.",
+		"amount":           c.Amount,
+		"currency":         "EUR",
+		"locale":           helper.Locale(),
+	})
+	return nil
+}
+
+func transferCommandFactory(cmdType es.CommandType, info es.Info) (server.Command, error) {
+	amount, _ := info["amount"].(float64)
+	return transfer{Amount: amount}, nil
+}
+
+func TestLocale(t *testing.T) {
+	locale.Default.Add("de", locale.Catalog{
+		"Amount must be positive.":             {locale.Other: "Der Betrag muss positiv sein."},
+		"Balance is low: {balance, currency}.": {locale.Other: "Kontostand ist niedrig: {balance, currency}."},
+		"Transferred {amount, currency}.":      {locale.Other: "{amount, currency} überwiesen."},
+	})
+	test := NewTest(t, transferCommandFactory, accountEntityFactory)
+	defer test.Shutdown()
+
+	serve := func(preferred string, info es.Info) *server.ServiceResult {
+		ctx := server.WithTenant(context.Background(), test.TenantId)
+		if preferred != "" {
+			ctx = server.WithLocale(ctx, preferred)
+		}
+		return (<-test.ServeContext(ctx, "conn", "transfer", info)).(*server.ServiceResult)
+	}
+
+	result := serve("de-AT", es.Info{"amount": 1234.5})
+	if result.Failure != nil {
+		t.Fatalf("Unexpected failure %v", result.Failure)
+	}
+	if text := result.Messages[0].Info[server.MessageText]; text != "1.234,50\u00a0€ überwiesen." {
+		t.Fatalf("Expected translated reply, got %q", text)
+	}
+	if result.Messages[0].Info["locale"] != "de-AT" {
+		t.Fatalf("Expected locale on helper, got %v", result.Messages[0].Info["locale"])
+	}
+	if desc := result.Diagnostics[0].Description; desc != "Kontostand ist niedrig: 5,00\u00a0€." {
+		t.Fatalf("Expected translated diagnostic, got %q", desc)
+	}
+
+	failed := serve("de", es.Info{"amount": -1.0})
+	if failure := failed.Failure.(errors.Error); failure.Description != "Der Betrag muss positiv sein." || failure.Code != errors.InvalidRequest {
+		t.Fatalf("Expected translated failure, got %v", failure)
+	}
+
+	untranslated := serve("", es.Info{"amount": 1.0})
+	if text := untranslated.Messages[0].Info[server.MessageText]; text != "Transferred {amount, currency}." {
+		t.Fatalf("Expected commands without locale not to be translated, got %q", text)
+	}
+}
@@ -0,0 +1,61 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/server"
+)
+
+type account struct {
+	AccountId es.EntityId `json:"account_id"`
+	Name      string      `json:"name"`
+}
+
+func (a *account) EntityId() es.EntityId     { return a.AccountId }
+func (a *account) EntityType() es.EntityType { return "account" }
+func (a *account) SchemaVersion() int        { return 2 }
+
+type openAccount struct {
+	Name string `json:"name"`
+}
+
+func (openAccount) CommandType() es.CommandType                 { return "open_account" }
+func (openAccount) Validate(helper server.CommandHelper) error  { return nil }
+func (openAccount) Authorize(helper server.CommandHelper) error { return nil }
+func (c openAccount) Handle(helper server.CommandHelper) error {
+	helper.CreateEntity(&account{AccountId: es.NewEntityId(), Name: c.Name})
+	return nil
+}
+
+func accountCommandFactory(cmdType es.CommandType, info es.Info) (server.Command, error) {
+	name, _ := info["name"].(string)
+	return openAccount{Name: name}, nil
+}
+
+func accountEntityFactory(et es.EntityType, id es.EntityId) (es.Entity, error) {
+	return &account{}, nil
+}
+
+func TestEventMetadata(t *testing.T) {
+	test := NewTest(t, accountCommandFactory, accountEntityFactory, server.WithAppVersion("1.2.3"))
+	defer test.Shutdown()
+
+	ctx := server.WithMetadata(context.Background(), es.Metadata{Principal: "user-2", TenantId: test.TenantId, Source: "10.0.0.1"})
+	ctx = server.WithPrincipal(ctx, server.Principal{UserId: "user-1", TenantId: test.TenantId})
+	result := (<-test.ServeContext(ctx, "conn", "open_account", es.Info{"name": "Savings"})).(*server.ServiceResult)
+	test.Send("conn", "open_account", es.Info{"name": "Checking"}).CheckSucceeded()
+
+	events, _ := test.FetchEvents(server.EventFilter{Principal: "user-1"})
+	if len(events) != 1 || events[0].EventId != result.Events[0].EventId {
+		t.Fatalf("Expected events of the principal, got %v", events)
+	}
+	if spoofed, _ := test.FetchEvents(server.EventFilter{Principal: "user-2"}); len(spoofed) != 0 {
+		t.Fatalf("Expected principal of the metadata to be ignored, got %v", spoofed)
+	}
+	metadata := events[0].Metadata
+	if metadata.Source != "10.0.0.1" || metadata.AppVersion != "1.2.3" || metadata.SchemaVersion != 2 {
+		t.Fatalf("Unexpected metadata %v", metadata)
+	}
+}
@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	legionErrors "github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/persistence/in_memory"
+	"github.com/andrew-suprun/legion/queue/durable"
+	"github.com/andrew-suprun/legion/server"
+)
+
+type placeOrder struct {
+	fail bool
+}
+
+func (placeOrder) CommandType() es.CommandType                 { return "place_order" }
+func (placeOrder) Validate(helper server.CommandHelper) error  { return nil }
+func (placeOrder) Authorize(helper server.CommandHelper) error { return nil }
+func (c placeOrder) Handle(helper server.CommandHelper) error {
+	helper.Reply("order_placed")
+	if c.fail {
+		return legionErrors.NewError(legionErrors.Failure, "out_of_stock", "Out of stock.")
+	}
+	return nil
+}
+
+func orderCommandFactory(cmdType es.CommandType, info es.Info) (server.Command, error) {
+	fail, _ := info["fail"].(bool)
+	return placeOrder{fail: fail}, nil
+}
+
+func TestOutbox(t *testing.T) {
+	ts := &testTimeService{}
+	p := in_memory.NewPersistence(noEntities)
+	var dispatched es.Messages
+	var unavailable error = errors.New("connection is not available")
+	dispatch := func(messages es.Messages) error {
+		if unavailable != nil {
+			return unavailable
+		}
+		dispatched = append(dispatched, messages...)
+		return nil
+	}
+
+	s := server.New(ts, p, orderCommandFactory, server.WithOutbox(p.(durable.Persistence), dispatch))
+	if result := (<-s.Serve("", "conn", "place_order", es.Info{"fail": true})).(*server.ServiceResult); len(result.Messages) != 0 {
+		t.Fatalf("Expected no messages from failed command, got %v", result.Messages)
+	}
+	<-s.Serve("", "conn", "place_order", nil)
+	s.DispatchOutbox()
+	s.Shutdown()
+	if len(dispatched) != 0 {
+		t.Fatalf("Unexpectedly dispatched %v", dispatched)
+	}
+
+	// the next run picks up messages left undelivered
+	unavailable = nil
+	s = server.New(ts, p, orderCommandFactory, server.WithOutbox(p.(durable.Persistence), dispatch))
+	defer s.Shutdown()
+	s.DispatchOutbox()
+	if len(dispatched) != 0 {
+		t.Fatalf("Expected messages in flight to wait for visibility timeout, got %v", dispatched)
+	}
+	ts.SetNow(time.Now().Add(time.Minute))
+	s.DispatchOutbox()
+	if len(dispatched) != 1 || dispatched[0].MessageType != "order_placed" {
+		t.Fatalf("Expected message to be dispatched after restart, got %v", dispatched)
+	}
+}
+
+// eventsOnlyPersistence hides OutboxPersistence of the wrapped persistence.
+type eventsOnlyPersistence struct {
+	server.Persistence
+}
+
+func (p eventsOnlyPersistence) Tenant(tenantId es.TenantId) server.Persistence {
+	return eventsOnlyPersistence{p.Persistence.Tenant(tenantId)}
+}
+
+type failingQueuePersistence struct {
+	durable.Persistence
+}
+
+func (failingQueuePersistence) PersistQueueMessage(message durable.Message) error {
+	return errors.New("connection refused")
+}
+
+func TestOutboxStoreFailure(t *testing.T) {
+	p := in_memory.NewPersistence(noEntities)
+	dispatch := func(messages es.Messages) error { return nil }
+	s := server.New(&testTimeService{}, eventsOnlyPersistence{p}, orderCommandFactory,
+		server.WithOutbox(failingQueuePersistence{p.(durable.Persistence)}, dispatch))
+	defer s.Shutdown()
+
+	result := (<-s.Serve("", "conn", "place_order", nil)).(*server.ServiceResult)
+	if result.Failure != nil || len(result.Diagnostics) != 1 || result.Diagnostics[0].Code != server.DatabaseError {
+		t.Fatalf("Expected committed command to succeed with diagnostic, got %v", result)
+	}
+}
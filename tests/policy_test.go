@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/server"
+)
+
+type document struct {
+	DocumentId es.EntityId `json:"document_id"`
+	Owner      es.EntityId `json:"owner"`
+	Title      string      `json:"title"`
+}
+
+func (d *document) EntityId() es.EntityId     { return d.DocumentId }
+func (d *document) EntityType() es.EntityType { return "document" }
+
+type createDocument struct{}
+
+func (createDocument) CommandType() es.CommandType                 { return "create_document" }
+func (createDocument) Validate(helper server.CommandHelper) error  { return nil }
+func (createDocument) Authorize(helper server.CommandHelper) error { return nil }
+func (createDocument) Handle(helper server.CommandHelper) error {
+	helper.CreateEntity(&document{DocumentId: "doc-1", Owner: helper.Principal().UserId})
+	return nil
+}
+
+type renameDocument struct {
+	Title string
+}
+
+func (renameDocument) CommandType() es.CommandType                 { return "rename_document" }
+func (renameDocument) Validate(helper server.CommandHelper) error  { return nil }
+func (renameDocument) Authorize(helper server.CommandHelper) error { return nil }
+func (renameDocument) Targets() []server.EntityKey {
+	return []server.EntityKey{{EntityType: "document", EntityId: "doc-1"}}
+}
+func (c renameDocument) Handle(helper server.CommandHelper) error {
+	entity, _ := helper.FetchEntity("document", "doc-1")
+	entity.(*document).Title = c.Title
+	return nil
+}
+
+func documentCommandFactory(cmdType es.CommandType, info es.Info) (server.Command, error) {
+	switch cmdType {
+	case "create_document":
+		return createDocument{}, nil
+	case "rename_document":
+		title, _ := info["title"].(string)
+		return renameDocument{Title: title}, nil
+	}
+	return schedulerCommandFactory(cmdType, info)
+}
+
+func documentEntityFactory(et es.EntityType, id es.EntityId) (es.Entity, error) {
+	return &document{}, nil
+}
+
+func TestPolicy(t *testing.T) {
+	test := NewTest(t, documentCommandFactory, documentEntityFactory, server.WithPolicy(server.Policy{
+		Roles: map[string][]string{
+			"editor": {"documents.create", "documents.edit"},
+			"viewer": {"documents.view"},
+		},
+		Commands: map[es.CommandType]server.Rule{
+			"create_document": {Permissions: []string{"documents.create"}},
+			"rename_document": {Permissions: []string{"documents.edit"}, Conditions: []string{"owner == principal"}},
+		},
+		DefaultDeny: true,
+	}))
+	defer test.Shutdown()
+
+	send := func(principal server.Principal, cmdType es.CommandType, info es.Info) *server.ServiceResult {
+		principal.TenantId = test.TenantId
+		ctx := server.WithPrincipal(context.Background(), principal)
+		return (<-test.ServeContext(ctx, "conn", cmdType, info)).(*server.ServiceResult)
+	}
+	denied := func(result *server.ServiceResult, key string, value interface{}) {
+		failure, ok := result.Failure.(errors.Error)
+		if !ok || failure.Code != errors.Unauthorized {
+			t.Fatalf("Expected command to be denied, got %v", result.Failure)
+		}
+		if _, ok := failure.Info[key]; !ok || (value != nil && failure.Info[key] != value) {
+			t.Fatalf("Expected denial to explain %s, got %v", key, failure.Info)
+		}
+	}
+
+	alice := server.Principal{UserId: "alice", Roles: []string{"editor"}}
+	bob := server.Principal{UserId: "bob", Roles: []string{"editor"}}
+	carol := server.Principal{UserId: "carol", Roles: []string{"viewer"}}
+
+	denied(send(carol, "create_document", nil), "missing_permissions", nil)
+	if result := send(alice, "create_document", nil); result.Failure != nil {
+		t.Fatalf("Unexpected failure: %v", result.Failure)
+	}
+	if result := send(alice, "rename_document", es.Info{"title": "Plan"}); result.Failure != nil {
+		t.Fatalf("Unexpected failure: %v", result.Failure)
+	}
+	result := send(bob, "rename_document", es.Info{"title": "Mine"})
+	denied(result, "condition", "owner == principal")
+	if info := result.Failure.(errors.Error).Info; info["value"] != nil || info["target"] != nil {
+		t.Fatalf("Denial discloses data of the target: %v", info)
+	}
+	denied(send(alice, "issue_invoice", nil), "command_type", es.CommandType("issue_invoice"))
+}
@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/persistence/in_memory"
+	"github.com/andrew-suprun/legion/server"
+)
+
+type issueInvoice struct{}
+
+func (issueInvoice) CommandType() es.CommandType                 { return "issue_invoice" }
+func (issueInvoice) Validate(helper server.CommandHelper) error  { return nil }
+func (issueInvoice) Authorize(helper server.CommandHelper) error { return nil }
+func (issueInvoice) Handle(helper server.CommandHelper) error {
+	helper.ScheduleCommand("send_reminder", es.Info{"invoice": "inv-1"}, helper.Now().Add(30*24*time.Hour))
+	return nil
+}
+
+type sendReminder struct{}
+
+func (sendReminder) CommandType() es.CommandType                 { return "send_reminder" }
+func (sendReminder) Validate(helper server.CommandHelper) error  { return nil }
+func (sendReminder) Authorize(helper server.CommandHelper) error { return nil }
+func (sendReminder) Handle(helper server.CommandHelper) error {
+	helper.Reply("reminder_sent", es.Info{"user_id": string(helper.Principal().UserId)})
+	return nil
+}
+
+func schedulerCommandFactory(cmdType es.CommandType, info es.Info) (server.Command, error) {
+	switch cmdType {
+	case "issue_invoice":
+		return issueInvoice{}, nil
+	case "send_reminder":
+		return sendReminder{}, nil
+	}
+	return nil, errors.NewError(errors.Failure, server.InvalidCommand, "invalid")
+}
+
+func noEntities(et es.EntityType, id es.EntityId) (es.Entity, error) {
+	return nil, nil
+}
+
+func TestScheduledCommand(t *testing.T) {
+	test := NewTest(t, schedulerCommandFactory, noEntities)
+	defer test.Shutdown()
+
+	test.Send("conn", "issue_invoice", nil).CheckSucceeded()
+
+	if replies := test.SetNow(time.Now().Add(29 * 24 * time.Hour)); len(replies) != 0 {
+		t.Fatalf("Reminder is sent too early.")
+	}
+	replies := test.SetNow(time.Now().Add(31 * 24 * time.Hour))
+	if len(replies) != 1 {
+		t.Fatalf("Expected reminder to be sent, got %d replies.", len(replies))
+	}
+	replies[0].CheckSucceeded().ValidateMessages(es.Message{ConnectionId: "conn", MessageType: "reminder_sent"})
+}
+
+func TestScheduledCommandPrincipal(t *testing.T) {
+	test := NewTest(t, schedulerCommandFactory, noEntities)
+	defer test.Shutdown()
+
+	ctx := server.WithPrincipal(server.WithTenant(context.Background(), test.TenantId), server.Principal{UserId: "user-1", TenantId: test.TenantId})
+	if result := (<-test.ServeContext(ctx, "conn", "issue_invoice", nil)).(*server.ServiceResult); result.Failure != nil {
+		t.Fatalf("Unexpected failure %v", result.Failure)
+	}
+	replies := test.SetNow(time.Now().Add(31 * 24 * time.Hour))
+	if len(replies) != 1 {
+		t.Fatalf("Expected reminder to be sent, got %d replies.", len(replies))
+	}
+	replies[0].CheckSucceeded().ValidateMessages(es.Message{ConnectionId: "conn", MessageType: "reminder_sent", Info: es.Info{"user_id": "user-1"}})
+}
+
+func TestScheduledCommandWithoutScheduler(t *testing.T) {
+	s := server.New(&testTimeService{}, in_memory.NewPersistence(noEntities), schedulerCommandFactory)
+	defer s.Shutdown()
+
+	result := (<-s.Serve("", "conn", "issue_invoice", nil)).(*server.ServiceResult)
+	if failure, ok := result.Failure.(errors.Error); !ok || failure.Code != server.SchedulerNotConfigured {
+		t.Fatalf("Expected %v, got %v", server.SchedulerNotConfigured, result.Failure)
+	}
+	if len(result.Scheduled) != 0 {
+		t.Fatalf("Unexpectedly scheduled %v", result.Scheduled)
+	}
+}
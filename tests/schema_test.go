@@ -0,0 +1,30 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/server"
+	"github.com/andrew-suprun/legion/validation"
+)
+
+func TestCommandSchema(t *testing.T) {
+	factoryCalled := false
+	commandFactory := func(cmdType es.CommandType, info es.Info) (server.Command, error) {
+		factoryCalled = true
+		return accountCommandFactory(cmdType, info)
+	}
+	test := NewTest(t, commandFactory, accountEntityFactory, server.WithSchema("open_account", validation.Object(map[string]*validation.Schema{
+		"name": validation.String().Require().LengthAtMost(10),
+	})))
+	defer test.Shutdown()
+
+	diagnostics := test.Send("conn", "open_account", es.Info{"name": "Far too long name"}).CheckFailed().Diagnostics()
+	if len(diagnostics) != 1 || diagnostics[0].Info["path"] != "$.name" {
+		t.Fatalf("Expected violation of $.name, got %v", diagnostics)
+	}
+	if factoryCalled {
+		t.Fatalf("Expected command not to be created from invalid info.")
+	}
+	test.Send("conn", "open_account", es.Info{"name": "Savings"}).CheckSucceeded()
+}
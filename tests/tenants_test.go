@@ -0,0 +1,60 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/andrew-suprun/legion/es"
+	"github.com/andrew-suprun/legion/server"
+)
+
+type findAccount struct {
+	AccountId es.EntityId `json:"account_id"`
+}
+
+func (findAccount) CommandType() es.CommandType                 { return "find_account" }
+func (findAccount) Validate(helper server.CommandHelper) error  { return nil }
+func (findAccount) Authorize(helper server.CommandHelper) error { return nil }
+func (c findAccount) Handle(helper server.CommandHelper) error {
+	entity, err := helper.FetchEntity("account", c.AccountId)
+	if err != nil {
+		return err
+	}
+	helper.Reply("account_found", es.Info{"found": entity != nil})
+	return nil
+}
+
+func tenantCommandFactory(cmdType es.CommandType, info es.Info) (server.Command, error) {
+	if cmdType == "find_account" {
+		accountId, _ := info["account_id"].(string)
+		return findAccount{AccountId: es.EntityId(accountId)}, nil
+	}
+	return accountCommandFactory(cmdType, info)
+}
+
+func TestTenantIsolation(t *testing.T) {
+	test := NewTest(t, tenantCommandFactory, accountEntityFactory)
+	defer test.Shutdown()
+	other := test.NewTenant()
+
+	events := test.Send("conn", "open_account", es.Info{"name": "Savings"}).CheckSucceeded().Events()
+	accountId := events[0].EntityId
+
+	found := test.Send("conn", "find_account", es.Info{"account_id": string(accountId)}).CheckSucceeded().Messages()
+	if found[0].Info["found"] != true {
+		t.Fatalf("Expected account to be found by its tenant.")
+	}
+	found = other.Send("conn", "find_account", es.Info{"account_id": string(accountId)}).CheckSucceeded().Messages()
+	if found[0].Info["found"] != false {
+		t.Fatalf("Expected account to be invisible to other tenant.")
+	}
+
+	if entity, _ := other.FetchEntity("account", accountId); entity != nil {
+		t.Fatalf("Unexpectedly fetched entity of other tenant.")
+	}
+	if events, _ := other.FetchEvents(server.EventFilter{}); len(events) != 0 {
+		t.Fatalf("Unexpectedly fetched events of other tenant: %v", events)
+	}
+	if events[0].Metadata.TenantId != test.TenantId {
+		t.Fatalf("Expected event to record the tenant, got %q", events[0].Metadata.TenantId)
+	}
+}
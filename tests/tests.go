@@ -11,6 +11,7 @@ import (
 	"github.com/andrew-suprun/legion/json"
 	"github.com/andrew-suprun/legion/persistence/in_memory"
 	"github.com/andrew-suprun/legion/persistence/mongo"
+	"github.com/andrew-suprun/legion/queue/durable"
 	"github.com/andrew-suprun/legion/server"
 )
 
@@ -19,8 +20,13 @@ type Test struct {
 	server.TimeService
 	server.Persistence
 	*server.Server
+	TenantId    es.TenantId
+	timeService *testTimeService
 }
 
+// IdempotencyRetention is how long tests servers keep results of commands served with idempotency keys.
+const IdempotencyRetention = 24 * time.Hour
+
 type ValueValidator interface {
 	Valid(value interface{}) bool
 }
@@ -40,6 +46,7 @@ func NewTest(
 	t *testing.T,
 	commandFactory server.CommandFactory,
 	entityFactory server.EntityFactory,
+	options ...server.Option,
 ) *Test {
 	mongoConnectString := os.Getenv("LEGION_MONGO")
 	ts := &testTimeService{}
@@ -51,21 +58,49 @@ func NewTest(
 		ts,
 		p,
 		commandFactory,
+		append(options,
+			server.WithScheduler(p.(durable.Persistence)),
+			server.WithIdempotency(p.(server.IdempotencyStore), IdempotencyRetention),
+		)...,
 	)
 
+	tenantId := es.TenantId(es.NewEntityId())
 	return &Test{
 		T:           t,
 		TimeService: ts,
-		Persistence: p,
+		Persistence: p.Tenant(tenantId),
 		Server:      serv,
+		TenantId:    tenantId,
+		timeService: ts,
 	}
 }
 
+// NewTenant returns the test for another tenant isolated from the tenants created so far.
+// The tests share the server and the time.
+func (t *Test) NewTenant() *Test {
+	tenant := *t
+	tenant.TenantId = es.TenantId(es.NewEntityId())
+	tenant.Persistence = t.Persistence.Tenant(tenant.TenantId)
+	return &tenant
+}
+
 func (t *Test) Send(connId es.EntityId, cmdType es.CommandType, cmdInfo es.Info) Reply {
-	resultChan := t.Server.Serve(connId, cmdType, cmdInfo)
+	resultChan := t.Server.Serve(t.TenantId, connId, cmdType, cmdInfo)
 	return t.newReply(resultChan)
 }
 
+// SetNow moves the time of the test and returns replies for scheduled commands that became due.
+func (t *Test) SetNow(timestamp time.Time) []Reply {
+	t.timeService.SetNow(timestamp)
+	var replies []Reply
+	for _, result := range t.Server.DispatchScheduled() {
+		resultChan := make(chan interface{}, 1)
+		resultChan <- result
+		replies = append(replies, t.newReply(resultChan))
+	}
+	return replies
+}
+
 type testTimeService struct {
 	timeshift time.Duration
 }
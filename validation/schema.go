@@ -0,0 +1,341 @@
+package validation
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+)
+
+type Type string
+
+const (
+	AnyType     Type = ""
+	StringType  Type = "string"
+	NumberType  Type = "number"
+	IntegerType Type = "integer"
+	BooleanType Type = "boolean"
+	ObjectType  Type = "object"
+	ListType    Type = "list"
+)
+
+// Schema describes expected shape of es.Info. Schemas are built with constructors and chained modifiers:
+//
+//	Object(map[string]*Schema{
+//		"name":  String().Require().LengthAtMost(100),
+//		"items": List(Object(map[string]*Schema{"qty": Integer().AtLeast(1)})).Require(),
+//	})
+//
+// Min and Max limit numbers; MinLength and MaxLength limit strings (in runes) and lists.
+// Schemas are plain data and can also be loaded from JSON; validators added with Check are not serialized.
+// Patterns are compiled by Matches, when schemas are loaded from JSON and by Compile.
+type Schema struct {
+	Type      Type               `json:"type,omitempty"`
+	Required  bool               `json:"required,omitempty"`
+	Min       *float64           `json:"min,omitempty"`
+	Max       *float64           `json:"max,omitempty"`
+	MinLength *int               `json:"min_length,omitempty"`
+	MaxLength *int               `json:"max_length,omitempty"`
+	Pattern   string             `json:"pattern,omitempty"`
+	Enum      []interface{}      `json:"enum,omitempty"`
+	Fields    map[string]*Schema `json:"fields,omitempty"`
+	Items     *Schema            `json:"items,omitempty"`
+	Strict    bool               `json:"strict,omitempty"`
+
+	pattern    *regexp.Regexp
+	validators []Validator
+}
+
+func Any() *Schema     { return &Schema{Type: AnyType} }
+func String() *Schema  { return &Schema{Type: StringType} }
+func Number() *Schema  { return &Schema{Type: NumberType} }
+func Integer() *Schema { return &Schema{Type: IntegerType} }
+func Boolean() *Schema { return &Schema{Type: BooleanType} }
+
+func Object(fields map[string]*Schema) *Schema {
+	return &Schema{Type: ObjectType, Fields: fields}
+}
+
+func List(items *Schema) *Schema {
+	return &Schema{Type: ListType, Items: items}
+}
+
+// OneOf accepts only the listed values.
+func OneOf(values ...interface{}) *Schema {
+	return &Schema{Enum: values}
+}
+
+func (s *Schema) Require() *Schema {
+	s.Required = true
+	return s
+}
+
+func (s *Schema) AtLeast(min float64) *Schema {
+	s.Min = &min
+	return s
+}
+
+func (s *Schema) AtMost(max float64) *Schema {
+	s.Max = &max
+	return s
+}
+
+func (s *Schema) Range(min, max float64) *Schema {
+	return s.AtLeast(min).AtMost(max)
+}
+
+func (s *Schema) LengthAtLeast(min int) *Schema {
+	s.MinLength = &min
+	return s
+}
+
+func (s *Schema) LengthAtMost(max int) *Schema {
+	s.MaxLength = &max
+	return s
+}
+
+func (s *Schema) Matches(pattern string) *Schema {
+	s.Pattern = pattern
+	s.pattern = regexp.MustCompile(pattern)
+	return s
+}
+
+type fields Schema
+
+// UnmarshalJSON compiles the pattern of the loaded schema and fails on an invalid one.
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*fields)(s)); err != nil {
+		return err
+	}
+	return s.compilePattern()
+}
+
+// Compile compiles patterns of schemas built without Matches, e.g. as struct literals.
+func (s *Schema) Compile() error {
+	if err := s.compilePattern(); err != nil {
+		return err
+	}
+	for _, name := range sortedNames(s.Fields) {
+		if err := s.Fields[name].Compile(); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.Compile()
+	}
+	return nil
+}
+
+func (s *Schema) compilePattern() error {
+	if s.Pattern == "" || s.pattern != nil {
+		return nil
+	}
+	pattern, err := regexp.Compile(s.Pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern %q: %v", s.Pattern, err)
+	}
+	s.pattern = pattern
+	return nil
+}
+
+// NoUnknownFields reports fields of the object missing in the schema.
+func (s *Schema) NoUnknownFields() *Schema {
+	s.Strict = true
+	return s
+}
+
+// Check runs the validators on values that passed the rest of the schema, e.g. String().Check(Email()).
+func (s *Schema) Check(validators ...Validator) *Schema {
+	s.validators = append(s.validators, validators...)
+	return s
+}
+
+// Validate reports every violation as a diagnostic with "path" of the offending value in Info, e.g. "$.items[2].qty".
+// Missing Info is validated as empty.
+func (s *Schema) Validate(info es.Info) errors.Errors {
+	var violations errors.Errors
+	if info == nil {
+		info = es.Info{}
+	}
+	s.validate("$", info, true, &violations)
+	return violations
+}
+
+func violation(violations *errors.Errors, path, desc string, info ...es.Info) {
+	*violations = append(*violations, errors.NewError(errors.Diagnostics, errors.InvalidRequest, desc, append([]es.Info{{"path": path}}, info...)...))
+}
+
+func (s *Schema) validate(path string, value interface{}, present bool, violations *errors.Errors) {
+	if !present || value == nil {
+		if s.Required {
+			violation(violations, path, "Value is required.")
+		}
+		return
+	}
+
+	count := len(*violations)
+	s.validateValue(path, value, violations)
+	if len(*violations) > count {
+		return
+	}
+	for _, validator := range s.validators {
+		switch err := validator(value).(type) {
+		case nil:
+		case errors.Error:
+			violation(violations, path, err.Description, err.Info)
+		default:
+			violation(violations, path, err.Error())
+		}
+	}
+}
+
+func (s *Schema) validateValue(path string, value interface{}, violations *errors.Errors) {
+	if len(s.Enum) > 0 && !s.allowed(value) {
+		violation(violations, path, "Value is not one of allowed values.", es.Info{"allowed": s.Enum})
+		return
+	}
+
+	switch s.Type {
+	case StringType:
+		str, ok := value.(string)
+		if !ok {
+			violation(violations, path, "Expected string.")
+			return
+		}
+		s.validateLength(path, utf8.RuneCountInString(str), violations)
+		if s.Pattern != "" {
+			pattern := s.pattern
+			if pattern == nil {
+				// schemas built without Matches and not compiled
+				var err error
+				if pattern, err = regexp.Compile(s.Pattern); err != nil {
+					violation(violations, path, "Pattern of the schema is invalid.", es.Info{"pattern": s.Pattern})
+					return
+				}
+			}
+			if !pattern.MatchString(str) {
+				violation(violations, path, "Value does not match the pattern.", es.Info{"pattern": s.Pattern})
+			}
+		}
+	case NumberType, IntegerType:
+		number, ok := toFloat(value)
+		if !ok {
+			violation(violations, path, "Expected number.")
+			return
+		}
+		if s.Type == IntegerType && number != math.Trunc(number) {
+			violation(violations, path, "Expected integer.")
+			return
+		}
+		if s.Min != nil && number < *s.Min {
+			violation(violations, path, "Value is too small.", es.Info{"min": *s.Min})
+		}
+		if s.Max != nil && number > *s.Max {
+			violation(violations, path, "Value is too large.", es.Info{"max": *s.Max})
+		}
+	case BooleanType:
+		if _, ok := value.(bool); !ok {
+			violation(violations, path, "Expected boolean.")
+		}
+	case ObjectType:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			violation(violations, path, "Expected object.")
+			return
+		}
+		for _, name := range sortedNames(s.Fields) {
+			field, present := object[name]
+			s.Fields[name].validate(path+"."+name, field, present, violations)
+		}
+		if s.Strict {
+			for _, name := range sortedNames(object) {
+				if _, known := s.Fields[name]; !known {
+					violation(violations, path+"."+name, "Unknown field.")
+				}
+			}
+		}
+	case ListType:
+		list, ok := toList(value)
+		if !ok {
+			violation(violations, path, "Expected list.")
+			return
+		}
+		s.validateLength(path, len(list), violations)
+		if s.Items != nil {
+			for i, item := range list {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, true, violations)
+			}
+		}
+	}
+}
+
+func (s *Schema) validateLength(path string, length int, violations *errors.Errors) {
+	if s.MinLength != nil && length < *s.MinLength {
+		violation(violations, path, "Value is too short.", es.Info{"min_length": *s.MinLength})
+	}
+	if s.MaxLength != nil && length > *s.MaxLength {
+		violation(violations, path, "Value is too long.", es.Info{"max_length": *s.MaxLength})
+	}
+}
+
+func (s *Schema) allowed(value interface{}) bool {
+	for _, allowed := range s.Enum {
+		if a, ok := toFloat(allowed); ok {
+			if v, ok := toFloat(value); ok && a == v {
+				return true
+			}
+			continue
+		}
+		// lists and objects are not comparable with ==
+		if reflect.DeepEqual(allowed, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+func toList(value interface{}) ([]interface{}, bool) {
+	if list, ok := value.([]interface{}); ok {
+		return list, true
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice {
+		return nil, false
+	}
+	list := make([]interface{}, v.Len())
+	for i := range list {
+		list[i] = v.Index(i).Interface()
+	}
+	return list, true
+}
+
+func sortedNames(m interface{}) []string {
+	var names []string
+	for _, key := range reflect.ValueOf(m).MapKeys() {
+		names = append(names, key.String())
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,105 @@
+package validation
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/andrew-suprun/legion/es"
+)
+
+var orderSchema = Object(map[string]*Schema{
+	"customer": Object(map[string]*Schema{
+		"name":  String().Require().LengthAtLeast(2).LengthAtMost(5),
+		"email": String().Matches(`^\S+@\S+$`),
+	}).Require(),
+	"priority": OneOf("low", "high"),
+	"items": List(Object(map[string]*Schema{
+		"sku": String().Require(),
+		"qty": Integer().Range(1, 10),
+	})).Require().LengthAtLeast(1),
+	"gift": Boolean(),
+}).NoUnknownFields()
+
+func TestSchemaReportsAllViolations(t *testing.T) {
+	var info es.Info
+	json.Unmarshal([]byte(`{
+		"customer": {"name": "Ünïcødé", "email": "nobody"},
+		"priority": "urgent",
+		"items": [{"sku": "a", "qty": 1}, {"qty": 1.5}, {"sku": "c", "qty": 11}],
+		"gift": "yes",
+		"coupon": "FREE"
+	}`), &info)
+
+	expected := map[string]string{
+		"$.customer.name":  "Value is too long.",
+		"$.customer.email": "Value does not match the pattern.",
+		"$.priority":       "Value is not one of allowed values.",
+		"$.items[1].sku":   "Value is required.",
+		"$.items[1].qty":   "Expected integer.",
+		"$.items[2].qty":   "Value is too large.",
+		"$.gift":           "Expected boolean.",
+		"$.coupon":         "Unknown field.",
+	}
+	violations := orderSchema.Validate(info)
+	if len(violations) != len(expected) {
+		t.Fatalf("Expected %d violations, got %v", len(expected), violations)
+	}
+	for _, v := range violations {
+		if expected[v.Info["path"].(string)] != v.Description {
+			t.Fatalf("Unexpected violation %v", v)
+		}
+	}
+}
+
+func TestSchemaAcceptsValidInfo(t *testing.T) {
+	info := es.Info{
+		"customer": es.Info{"name": "Ann"},
+		"items":    []es.Info{{"sku": "a", "qty": 2}},
+	}
+	if violations := orderSchema.Validate(info); len(violations) != 0 {
+		t.Fatalf("Unexpected violations %v", violations)
+	}
+	if violations := orderSchema.Validate(nil); len(violations) != 2 {
+		t.Fatalf("Expected required fields to be reported, got %v", violations)
+	}
+}
+
+func TestSchemaPatterns(t *testing.T) {
+	var schema Schema
+	if err := json.Unmarshal([]byte(`{"type": "object", "fields": {"code": {"type": "string", "pattern": "^[A-Z]+$"}}}`), &schema); err != nil {
+		t.Fatal(err)
+	}
+	if schema.Fields["code"].pattern == nil {
+		t.Fatal("Expected pattern to be compiled when loaded")
+	}
+	if violations := schema.Validate(es.Info{"code": "abc"}); len(violations) != 1 {
+		t.Fatalf("Expected pattern violation, got %v", violations)
+	}
+	if err := json.Unmarshal([]byte(`{"type": "list", "items": {"type": "string", "pattern": "[a-"}}`), &schema); err == nil {
+		t.Fatal("Expected error for invalid pattern")
+	}
+
+	literal := &Schema{Type: StringType, Pattern: "("}
+	if err := literal.Compile(); err == nil {
+		t.Fatal("Expected error for invalid pattern")
+	}
+	if violations := Object(map[string]*Schema{"code": literal}).Validate(es.Info{"code": "a"}); len(violations) != 1 {
+		t.Fatalf("Expected invalid pattern to be reported, got %v", violations)
+	}
+}
+
+func TestSchemaListEnum(t *testing.T) {
+	var schema Schema
+	if err := json.Unmarshal([]byte(`{"type": "object", "fields": {"size": {"type": "list", "enum": [[1, 2]], "items": {"type": "number"}}}}`), &schema); err != nil {
+		t.Fatal(err)
+	}
+	var allowed, other es.Info
+	json.Unmarshal([]byte(`{"size": [1, 2]}`), &allowed)
+	json.Unmarshal([]byte(`{"size": [2, 1]}`), &other)
+	if violations := schema.Validate(allowed); len(violations) != 0 {
+		t.Fatalf("Unexpected violations %v", violations)
+	}
+	if violations := schema.Validate(other); len(violations) != 1 {
+		t.Fatalf("Expected value not in enum to be reported, got %v", violations)
+	}
+}
@@ -1,11 +1,6 @@
 package validation
 
-import (
-	"regexp"
-)
-
-var EmailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.([^@\s]+){2,}$`)
-
+// IsValidEmail reports whether the string is an email address accepted by Email validator.
 func IsValidEmail(str string) bool {
-	return EmailPattern.MatchString(str)
+	return isEmail(str)
 }
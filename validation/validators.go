@@ -0,0 +1,250 @@
+package validation
+
+import (
+	"math/big"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+)
+
+// Validator returns errors.Error with InvalidRequest code if the value is not valid and nil otherwise.
+//
+// Descriptions of returned errors are message keys for locale.Translate with {placeholders}
+// filled from Info of the error; "rule" in Info names the failed validator.
+type Validator func(value interface{}) error
+
+func invalid(rule, desc string, info ...es.Info) error {
+	return errors.NewError(errors.Diagnostics, errors.InvalidRequest, desc, append([]es.Info{{"rule": rule}}, info...)...)
+}
+
+// All returns the first error of the validators.
+func All(validators ...Validator) Validator {
+	return func(value interface{}) error {
+		for _, validator := range validators {
+			if err := validator(value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Optional lets missing values and empty strings pass the validator.
+func Optional(validator Validator) Validator {
+	return func(value interface{}) error {
+		if value == nil || value == "" {
+			return nil
+		}
+		return validator(value)
+	}
+}
+
+// stringValidator checks that the value is a string before running the check.
+func stringValidator(check func(str string) error) Validator {
+	return func(value interface{}) error {
+		str, ok := value.(string)
+		if !ok {
+			return invalid("string", "Value must be a string.")
+		}
+		return check(str)
+	}
+}
+
+// RuneLength limits length of strings in runes; zero max means unlimited.
+func RuneLength(min, max int) Validator {
+	return stringValidator(func(str string) error {
+		length := utf8.RuneCountInString(str)
+		if length < min {
+			return invalid("length", "Value must be at least {min} characters long.", es.Info{"min": min})
+		}
+		if max > 0 && length > max {
+			return invalid("length", "Value must be at most {max} characters long.", es.Info{"max": max})
+		}
+		return nil
+	})
+}
+
+const emailAtext = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!#$%&'*+/=?^_`{|}~-"
+
+var domainLabel = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?$`)
+
+// EmailPattern is the loose pattern emails were matched with before Email validator.
+//
+// Deprecated: use Email or IsValidEmail.
+var EmailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.([^@\s]+){2,}$`)
+
+// Email accepts addresses with dot-atom local part and domain name as defined by RFC 5322;
+// quoted local parts, comments and address literals are not accepted.
+func Email() Validator {
+	return stringValidator(func(str string) error {
+		if !isEmail(str) {
+			return invalid("email", "Value must be an email address.")
+		}
+		return nil
+	})
+}
+
+func isEmail(str string) bool {
+	at := strings.LastIndexByte(str, '@')
+	if at < 0 || len(str) > 254 {
+		return false
+	}
+	local, domain := str[:at], str[at+1:]
+	if len(local) == 0 || len(local) > 64 {
+		return false
+	}
+	for _, atom := range strings.Split(local, ".") {
+		if atom == "" {
+			return false
+		}
+		for _, r := range atom {
+			if !strings.ContainsRune(emailAtext, r) {
+				return false
+			}
+		}
+	}
+	return isDomain(domain)
+}
+
+func isDomain(domain string) bool {
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 || len(domain) > 253 {
+		return false
+	}
+	for _, label := range labels {
+		if !domainLabel.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+// URL accepts absolute URLs with a host and one of the schemes, http and https by default.
+func URL(schemes ...string) Validator {
+	if len(schemes) == 0 {
+		schemes = []string{"http", "https"}
+	}
+	return stringValidator(func(str string) error {
+		u, err := url.Parse(str)
+		if err != nil || u.Host == "" {
+			return invalid("url", "Value must be a URL.")
+		}
+		for _, scheme := range schemes {
+			if strings.EqualFold(u.Scheme, scheme) {
+				return nil
+			}
+		}
+		return invalid("url", "URL scheme must be one of {schemes}.", es.Info{"schemes": strings.Join(schemes, ", ")})
+	})
+}
+
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+// Phone accepts phone numbers in E.164 format, e.g. +14155552671.
+func Phone() Validator {
+	return stringValidator(func(str string) error {
+		if !e164.MatchString(str) {
+			return invalid("phone", "Value must be a phone number in international format.")
+		}
+		return nil
+	})
+}
+
+// Currency accepts ISO 4217 currency codes.
+func Currency() Validator {
+	return stringValidator(func(str string) error {
+		if !currencies[str] {
+			return invalid("currency", "Value must be a currency code.")
+		}
+		return nil
+	})
+}
+
+// Country accepts ISO 3166-1 alpha-2 country codes.
+func Country() Validator {
+	return stringValidator(func(str string) error {
+		if !countries[str] {
+			return invalid("country", "Value must be a country code.")
+		}
+		return nil
+	})
+}
+
+// IBAN accepts international bank account numbers of known countries with valid check digits.
+// Spaces used to group characters are ignored.
+func IBAN() Validator {
+	return stringValidator(func(str string) error {
+		iban := strings.ToUpper(strings.Replace(str, " ", "", -1))
+		if len(iban) < 4 || ibanLengths[iban[:2]] != len(iban) {
+			return invalid("iban", "Value must be an IBAN.")
+		}
+		if !ibanChecksumValid(iban) {
+			return invalid("iban", "IBAN check digits are not valid.")
+		}
+		return nil
+	})
+}
+
+// ibanChecksumValid moves the country code and check digits to the end, replaces letters
+// with numbers (A = 10, ..., Z = 35) and checks the remainder of dividing by 97.
+func ibanChecksumValid(iban string) bool {
+	var digits strings.Builder
+	for _, r := range iban[4:] + iban[:4] {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r >= 'A' && r <= 'Z':
+			digits.WriteString(strconv.Itoa(int(r - 'A' + 10)))
+		default:
+			return false
+		}
+	}
+	number, ok := new(big.Int).SetString(digits.String(), 10)
+	return ok && new(big.Int).Mod(number, big.NewInt(97)).Int64() == 1
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// UUID accepts UUIDs in canonical textual form.
+func UUID() Validator {
+	return stringValidator(func(str string) error {
+		if !uuidPattern.MatchString(str) {
+			return invalid("uuid", "Value must be a UUID.")
+		}
+		return nil
+	})
+}
+
+// DateBetween accepts time.Time values and strings in RFC 3339 or 2006-01-02 format
+// within [from, to]; zero bound is open.
+func DateBetween(from, to time.Time) Validator {
+	return func(value interface{}) error {
+		var date time.Time
+		switch v := value.(type) {
+		case time.Time:
+			date = v
+		case string:
+			var err error
+			if date, err = time.Parse(time.RFC3339, v); err != nil {
+				if date, err = time.Parse("2006-01-02", v); err != nil {
+					return invalid("date", "Value must be a date.")
+				}
+			}
+		default:
+			return invalid("date", "Value must be a date.")
+		}
+		if !from.IsZero() && date.Before(from) {
+			return invalid("date", "Date must not be before {from}.", es.Info{"from": from.Format("2006-01-02")})
+		}
+		if !to.IsZero() && date.After(to) {
+			return invalid("date", "Date must not be after {to}.", es.Info{"to": to.Format("2006-01-02")})
+		}
+		return nil
+	}
+}
@@ -0,0 +1,112 @@
+package validation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andrew-suprun/legion/errors"
+	"github.com/andrew-suprun/legion/es"
+)
+
+func TestValidators(t *testing.T) {
+	may1 := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	jun1 := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name      string
+		validator Validator
+		valid     []interface{}
+		invalid   []interface{}
+	}{
+		{"email", Email(),
+			[]interface{}{"john.doe@example.com", "a+tag@sub.example.co.uk", "o'brien@example.org", "x@example-domain.io"},
+			[]interface{}{"john..doe@example.com", ".john@example.com", "john@example", "john@-example.com", "john doe@example.com", "john@exa_mple.com", "@example.com", 42}},
+		{"url", URL(),
+			[]interface{}{"https://example.com", "http://example.com:8080/path?q=1"},
+			[]interface{}{"ftp://example.com", "example.com", "https://", "://bad"}},
+		{"phone", Phone(),
+			[]interface{}{"+14155552671", "+442071838750"},
+			[]interface{}{"14155552671", "+04155552671", "+1 415 555 2671", "+1234567890123456"}},
+		{"currency", Currency(),
+			[]interface{}{"USD", "EUR", "JPY"},
+			[]interface{}{"usd", "ABC", "US"}},
+		{"country", Country(),
+			[]interface{}{"US", "DE", "JP"},
+			[]interface{}{"us", "XX", "USA"}},
+		{"iban", IBAN(),
+			[]interface{}{"GB82 WEST 1234 5698 7654 32", "DE89370400440532013000", "gb82west12345698765432"},
+			[]interface{}{"GB82WEST12345698765433", "GB82WEST123456987654", "ZZ82WEST12345698765432", "GB82WEST1234569876543!"}},
+		{"uuid", UUID(),
+			[]interface{}{"123e4567-e89b-12d3-a456-426614174000", "123E4567-E89B-12D3-A456-426614174000"},
+			[]interface{}{"123e4567e89b12d3a456426614174000", "123e4567-e89b-12d3-a456-42661417400g"}},
+		{"date", DateBetween(may1, jun1),
+			[]interface{}{"2020-05-01", "2020-05-15T10:00:00Z", jun1},
+			[]interface{}{"2020-04-30", "2020-06-01T00:00:01Z", "May 5", 20200505}},
+		{"length", RuneLength(2, 3),
+			[]interface{}{"ab", "äöü"},
+			[]interface{}{"a", "abcd", "äöüß"}},
+	}
+
+	for _, c := range cases {
+		for _, value := range c.valid {
+			if err := c.validator(value); err != nil {
+				t.Errorf("%s: expected %v to be valid, got %v", c.name, value, err)
+			}
+		}
+		for _, value := range c.invalid {
+			err, ok := c.validator(value).(errors.Error)
+			if !ok || err.Code != errors.InvalidRequest {
+				t.Errorf("%s: expected %v to be invalid, got %v", c.name, value, err)
+			}
+		}
+	}
+}
+
+func TestValidatorErrorsCarryParameters(t *testing.T) {
+	err := RuneLength(0, 3)("abcd").(errors.Error)
+	if err.Description != "Value must be at most {max} characters long." || err.Info["max"] != 3 || err.Info["rule"] != "length" {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
+
+func TestComposedValidators(t *testing.T) {
+	validator := Optional(All(RuneLength(0, 20), Email()))
+	if err := validator(""); err != nil {
+		t.Fatalf("Expected empty value to pass, got %v", err)
+	}
+	if err := validator("averyverylongname@example.com"); err.(errors.Error).Info["rule"] != "length" {
+		t.Fatalf("Expected length error first, got %v", err)
+	}
+	if err := validator("ann@example"); err.(errors.Error).Info["rule"] != "email" {
+		t.Fatalf("Expected email error, got %v", err)
+	}
+}
+
+func TestSchemaRunsValidators(t *testing.T) {
+	schema := Object(map[string]*Schema{
+		"email": String().Require().Check(Email()),
+		"phone": String().Check(Phone()),
+	})
+	violations := schema.Validate(es.Info{"email": "ann@example", "phone": 42})
+	if len(violations) != 2 {
+		t.Fatalf("Expected 2 violations, got %v", violations)
+	}
+	if violations[0].Info["path"] != "$.email" || violations[0].Info["rule"] != "email" {
+		t.Fatalf("Unexpected violation %v", violations[0])
+	}
+	if violations[1].Info["path"] != "$.phone" || violations[1].Description != "Expected string." {
+		t.Fatalf("Unexpected violation %v", violations[1])
+	}
+}
+
+func TestIsValidEmail(t *testing.T) {
+	if !IsValidEmail("a.b+c@example.com") || IsValidEmail("a@b@example.com") || IsValidEmail("a@example.c_m") {
+		t.Fatal("IsValidEmail is not consistent with Email")
+	}
+}
+
+func TestEmailPattern(t *testing.T) {
+	if !EmailPattern.MatchString("ann@example.com") || EmailPattern.MatchString("ann@example") {
+		t.Fatal("Expected deprecated pattern to keep matching emails")
+	}
+}